@@ -2,18 +2,30 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 )
 
-func say(s string) {
+// Clock is the part of the time package say uses,
+// so a fake clock can stand in for it (see 25-virtual-clock.go).
+type Clock interface {
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func say(clock Clock, w io.Writer, s string) {
 	for i := 0; i < 5; i++ {
-		time.Sleep(100 * time.Millisecond)
-		fmt.Println(s)
+		clock.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(w, s)
 	}
 }
 
 func main() {
 	// A goroutine starts with the "go" keyword
-	go say("world")
-	say("hello")
+	go say(realClock{}, os.Stdout, "world")
+	say(realClock{}, os.Stdout, "hello")
 }
@@ -0,0 +1,208 @@
+/*
+ 1. a compact binary encoding of `tree.Tree`
+    pre-order, one marker byte per node, values as varints
+ 2. a JSON encoding with the same shape
+ 3. an ASCII printer that shows the shape of the tree
+    (`tree.Tree.String()` hides it behind parentheses)
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/tour/tree"
+)
+
+// markers written before every (possibly nil) node
+const (
+	nilNode  byte = 0
+	someNode byte = 1
+)
+
+// MarshalBinary encodes t in pre-order.
+// Every node is a marker byte followed by its value as a varint,
+// and nil children are a single `nilNode` byte.
+func MarshalBinary(t *tree.Tree) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	var encode func(t *tree.Tree)
+	encode = func(t *tree.Tree) {
+		if t == nil {
+			buf.WriteByte(nilNode)
+			return
+		}
+		buf.WriteByte(someNode)
+		n := binary.PutVarint(tmp[:], int64(t.Value))
+		buf.Write(tmp[:n])
+		encode(t.Left)
+		encode(t.Right)
+	}
+	encode(t)
+	return buf.Bytes()
+}
+
+// UnmarshalBinary decodes a tree written by MarshalBinary.
+func UnmarshalBinary(data []byte) (*tree.Tree, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	t, err := decodeBinary(r)
+	if err != nil {
+		return nil, err
+	}
+	// the whole input should be consumed by exactly one tree
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, errors.New("tree: trailing data after encoded tree")
+	}
+	return t, nil
+}
+
+func decodeBinary(r *bufio.Reader) (*tree.Tree, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("tree: reading marker: %w", io.ErrUnexpectedEOF)
+	}
+	switch marker {
+	case nilNode:
+		return nil, nil
+	case someNode:
+	default:
+		return nil, fmt.Errorf("tree: invalid marker %#x", marker)
+	}
+	v, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("tree: reading value: %w", err)
+	}
+	t := &tree.Tree{Value: int(v)}
+	if t.Left, err = decodeBinary(r); err != nil {
+		return nil, err
+	}
+	if t.Right, err = decodeBinary(r); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// jsonTree mirrors `tree.Tree` with short, lower-case keys
+// and leaves out nil children.
+type jsonTree struct {
+	Value int       `json:"v"`
+	Left  *jsonTree `json:"l,omitempty"`
+	Right *jsonTree `json:"r,omitempty"`
+}
+
+func toJSONTree(t *tree.Tree) *jsonTree {
+	if t == nil {
+		return nil
+	}
+	return &jsonTree{t.Value, toJSONTree(t.Left), toJSONTree(t.Right)}
+}
+
+func fromJSONTree(j *jsonTree) *tree.Tree {
+	if j == nil {
+		return nil
+	}
+	return &tree.Tree{Left: fromJSONTree(j.Left), Value: j.Value, Right: fromJSONTree(j.Right)}
+}
+
+// MarshalJSON encodes t as nested {"v", "l", "r"} objects.
+// An empty tree is encoded as `null`.
+func MarshalJSON(t *tree.Tree) ([]byte, error) {
+	return json.Marshal(toJSONTree(t))
+}
+
+// UnmarshalJSON decodes a tree written by MarshalJSON.
+func UnmarshalJSON(data []byte) (*tree.Tree, error) {
+	var j *jsonTree
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	return fromJSONTree(j), nil
+}
+
+// Render draws t sideways with ASCII branches, left child first.
+// Missing children are drawn as `L: -` or `R: -`
+// so that an unbalanced tree is easy to spot.
+func Render(t *tree.Tree) string {
+	if t == nil {
+		return "-\n"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d\n", t.Value)
+	renderChildren(&sb, t, "")
+	return sb.String()
+}
+
+func renderChildren(sb *strings.Builder, t *tree.Tree, prefix string) {
+	// a leaf needs no `L: -` / `R: -` lines
+	if t.Left == nil && t.Right == nil {
+		return
+	}
+	renderNode(sb, t.Left, "L", prefix, false)
+	renderNode(sb, t.Right, "R", prefix, true)
+}
+
+func renderNode(sb *strings.Builder, t *tree.Tree, side, prefix string, last bool) {
+	branch, indent := "|-- ", "|   "
+	if last {
+		branch, indent = "`-- ", "    "
+	}
+	if t == nil {
+		fmt.Fprintf(sb, "%s%s%s: -\n", prefix, branch, side)
+		return
+	}
+	fmt.Fprintf(sb, "%s%s%s: %d\n", prefix, branch, side, t.Value)
+	renderChildren(sb, t, prefix+indent)
+}
+
+// Height returns the number of nodes on the longest root-to-leaf path.
+func Height(t *tree.Tree) int {
+	if t == nil {
+		return 0
+	}
+	l, r := Height(t.Left), Height(t.Right)
+	if l > r {
+		return l + 1
+	}
+	return r + 1
+}
+
+func main() {
+	t := tree.New(1)
+	fmt.Println(t)
+	fmt.Print(Render(t))
+	// a random tree of 10 values is usually 4-7 levels deep,
+	// while a balanced one would be 4
+	fmt.Println("height:", Height(t))
+
+	// binary round trip
+	b := MarshalBinary(t)
+	bt, err := UnmarshalBinary(b)
+	if err != nil {
+		fmt.Println("binary:", err)
+		return
+	}
+	fmt.Printf("binary: %d bytes, same: %v\n", len(b), bt.String() == t.String())
+
+	// JSON round trip
+	j, err := MarshalJSON(t)
+	if err != nil {
+		fmt.Println("json:", err)
+		return
+	}
+	jt, err := UnmarshalJSON(j)
+	if err != nil {
+		fmt.Println("json:", err)
+		return
+	}
+	fmt.Printf("json: %s\njson: same: %v\n", j, jt.String() == t.String())
+
+	// broken input is reported instead of panicking
+	_, err = UnmarshalBinary(b[:len(b)-1])
+	fmt.Println("truncated:", err)
+}
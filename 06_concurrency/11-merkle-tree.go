@@ -0,0 +1,334 @@
+/*
+ 1. a binary search tree whose nodes keep a Merkle-style hash
+    hash(node) = sha256(value, hash(left), hash(right))
+    updated along the path on every insert and delete
+ 2. the tree is a treap whose priorities are a fixed hash of the values,
+    so its shape only depends on the set of values, not on the order
+    they were inserted in: two replicas of the same set have the same tree
+ 3. equal root hashes <=> same values, decided in O(1)
+ 4. Diff only descends into subtrees whose hashes differ,
+    and returns the values only one side has
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Hash is the Merkle hash of a subtree.
+// The zero Hash stands for the empty tree.
+type Hash [sha256.Size]byte
+
+// Node is a node of a MerkleTree.
+// Hash covers the value and the hashes of both children.
+type Node struct {
+	Left  *Node
+	Value int
+	Right *Node
+	Hash  Hash
+}
+
+func (n *Node) hash() Hash {
+	if n == nil {
+		return Hash{}
+	}
+	return n.Hash
+}
+
+// rehash recomputes the hash of n from its value and children.
+// It must be called bottom-up after a child has changed.
+func (n *Node) rehash() {
+	var buf [8 + 2*sha256.Size]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(n.Value))
+	l, r := n.Left.hash(), n.Right.hash()
+	copy(buf[8:], l[:])
+	copy(buf[8+sha256.Size:], r[:])
+	n.Hash = sha256.Sum256(buf[:])
+}
+
+// priority is the heap priority of v in the treap.
+// It is a fixed function of v (the splitmix64 finalizer),
+// so the shape of the tree depends on the set of values alone.
+func priority(v int) uint64 {
+	x := uint64(v) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// above reports whether a belongs above b in the treap.
+// Equal priorities are ordered by value, to keep the shape unique.
+func above(a, b *Node) bool {
+	pa, pb := priority(a.Value), priority(b.Value)
+	if pa != pb {
+		return pa > pb
+	}
+	return a.Value < b.Value
+}
+
+// rotateRight lifts the left child of n into its place.
+func rotateRight(n *Node) *Node {
+	l := n.Left
+	n.Left = l.Right
+	n.rehash()
+	l.Right = n
+	l.rehash()
+	return l
+}
+
+// rotateLeft lifts the right child of n into its place.
+func rotateLeft(n *Node) *Node {
+	r := n.Right
+	n.Right = r.Left
+	n.rehash()
+	r.Left = n
+	r.rehash()
+	return r
+}
+
+// MerkleTree is a set of ints stored in a treap,
+// a binary search tree that is also a heap by priority.
+type MerkleTree struct {
+	root *Node
+	size int
+}
+
+// Root returns the root node, or nil for an empty tree.
+func (t *MerkleTree) Root() *Node {
+	return t.root
+}
+
+// Hash returns the hash of the whole tree.
+func (t *MerkleTree) Hash() Hash {
+	return t.root.hash()
+}
+
+// Len returns the number of values in the tree.
+func (t *MerkleTree) Len() int {
+	return t.size
+}
+
+// Insert adds v to the tree and reports whether it was not already present.
+func (t *MerkleTree) Insert(v int) bool {
+	var added bool
+	t.root, added = insertNode(t.root, v)
+	if added {
+		t.size++
+	}
+	return added
+}
+
+func insertNode(n *Node, v int) (*Node, bool) {
+	if n == nil {
+		n = &Node{Value: v}
+		n.rehash()
+		return n, true
+	}
+	var added bool
+	switch {
+	case v < n.Value:
+		n.Left, added = insertNode(n.Left, v)
+		if added && above(n.Left, n) {
+			return rotateRight(n), true
+		}
+	case v > n.Value:
+		n.Right, added = insertNode(n.Right, v)
+		if added && above(n.Right, n) {
+			return rotateLeft(n), true
+		}
+	default:
+		return n, false
+	}
+	// only nodes on the path to v need a new hash
+	if added {
+		n.rehash()
+	}
+	return n, added
+}
+
+// Delete removes v from the tree and reports whether it was present.
+func (t *MerkleTree) Delete(v int) bool {
+	var deleted bool
+	t.root, deleted = deleteNode(t.root, v)
+	if deleted {
+		t.size--
+	}
+	return deleted
+}
+
+func deleteNode(n *Node, v int) (*Node, bool) {
+	if n == nil {
+		return nil, false
+	}
+	var deleted bool
+	switch {
+	case v < n.Value:
+		n.Left, deleted = deleteNode(n.Left, v)
+	case v > n.Value:
+		n.Right, deleted = deleteNode(n.Right, v)
+	default:
+		if n.Left == nil {
+			return n.Right, true
+		}
+		if n.Right == nil {
+			return n.Left, true
+		}
+		// two children: rotate n down below the one that belongs above,
+		// until it has at most one child
+		if above(n.Left, n.Right) {
+			n = rotateRight(n)
+			n.Right, deleted = deleteNode(n.Right, v)
+		} else {
+			n = rotateLeft(n)
+			n.Left, deleted = deleteNode(n.Left, v)
+		}
+	}
+	if deleted {
+		n.rehash()
+	}
+	return n, deleted
+}
+
+// Same determines whether the trees
+// t1 and t2 contain the same values.
+// The shape only depends on the values, so the root hashes
+// are equal exactly when the values are, and nothing is walked.
+func Same(t1, t2 *MerkleTree) bool {
+	return t1.Hash() == t2.Hash()
+}
+
+// Difference is a pair of subtrees at the same position that differ.
+// Path is the way down from the root, e.g. "LRL" (empty for the root).
+// OnlyA and OnlyB are the values of one subtree missing in the other.
+type Difference struct {
+	Path         string
+	A, B         *Node
+	OnlyA, OnlyB []int
+}
+
+// Diff returns the topmost differing subtrees of t1 and t2.
+// Subtrees with equal hashes are skipped without being visited,
+// so two large trees with a few changes are compared quickly.
+// A pair is reported as soon as the values differ or a side is missing.
+// Both trees have the shape of their values, so the nodes above a pair
+// are the same, and the two subtrees hold the values of the same range.
+func Diff(t1, t2 *MerkleTree) []Difference {
+	var diffs []Difference
+	var diff func(a, b *Node, path string)
+	diff = func(a, b *Node, path string) {
+		if a.hash() == b.hash() {
+			return
+		}
+		if a == nil || b == nil || a.Value != b.Value {
+			onlyA, onlyB := subtract(inOrder(a), inOrder(b))
+			diffs = append(diffs, Difference{path, a, b, onlyA, onlyB})
+			return
+		}
+		diff(a.Left, b.Left, path+"L")
+		diff(a.Right, b.Right, path+"R")
+	}
+	diff(t1.root, t2.root, "")
+	return diffs
+}
+
+// inOrder returns the values of the subtree n in order.
+func inOrder(n *Node) []int {
+	var vs []int
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil {
+			return
+		}
+		walk(n.Left)
+		vs = append(vs, n.Value)
+		walk(n.Right)
+	}
+	walk(n)
+	return vs
+}
+
+// subtract returns the values only in a and the values only in b,
+// both sorted like a and b.
+func subtract(a, b []int) (onlyA, onlyB []int) {
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			onlyA, a = append(onlyA, a[0]), a[1:]
+		case a[0] > b[0]:
+			onlyB, b = append(onlyB, b[0]), b[1:]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return append(onlyA, a...), append(onlyB, b...)
+}
+
+func (d Difference) String() string {
+	value := func(n *Node) string {
+		if n == nil {
+			return "-"
+		}
+		return fmt.Sprint(n.Value)
+	}
+	path := d.Path
+	if path == "" {
+		path = "root"
+	}
+	return fmt.Sprintf("%s: %s vs %s, only in A %v, only in B %v",
+		path, value(d.A), value(d.B), d.OnlyA, d.OnlyB)
+}
+
+func main() {
+	values := []int{50, 30, 70, 20, 40, 60, 80}
+
+	var t1, t2 MerkleTree
+	for _, v := range values {
+		t1.Insert(v)
+		t2.Insert(v)
+	}
+	// identical trees: decided by comparing two hashes
+	fmt.Println("same hash:", t1.Hash() == t2.Hash(), "same:", Same(&t1, &t2))
+
+	// one replica gets an extra value
+	t2.Insert(65)
+	fmt.Println("same:", Same(&t1, &t2))
+	// LRL: - vs 65, only in A [], only in B [65]
+	for _, d := range Diff(&t1, &t2) {
+		fmt.Println(d)
+	}
+
+	// deleting it brings the hashes back together
+	t2.Delete(65)
+	fmt.Println("same hash after delete:", t1.Hash() == t2.Hash())
+
+	// same values in another order: the same shape, so the same hash
+	var t3 MerkleTree
+	for i := len(values) - 1; i >= 0; i-- {
+		t3.Insert(values[i])
+	}
+	fmt.Println("same hash:", t1.Hash() == t3.Hash(), "same:", Same(&t1, &t3))
+
+	// two replicas of 1000 values, filled in opposite orders,
+	// one of them with an extra value and one value missing:
+	// Diff finds just those, in a few small subtrees
+	// replicas same: true
+	// only in r1: [500] only in r2: [1001] values in the differing subtrees: 6
+	var r1, r2 MerkleTree
+	for i := 0; i < 1000; i++ {
+		r1.Insert(i * 2)
+		r2.Insert((999 - i) * 2)
+	}
+	fmt.Println("replicas same:", Same(&r1, &r2))
+	r2.Insert(1001)
+	r2.Delete(500)
+	var onlyA, onlyB []int
+	compared := 0
+	for _, d := range Diff(&r1, &r2) {
+		onlyA = append(onlyA, d.OnlyA...)
+		onlyB = append(onlyB, d.OnlyB...)
+		compared += len(inOrder(d.A)) + len(inOrder(d.B))
+	}
+	fmt.Println("only in r1:", onlyA, "only in r2:", onlyB, "values in the differing subtrees:", compared)
+}
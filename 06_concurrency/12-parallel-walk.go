@@ -0,0 +1,190 @@
+/*
+ 1. `Walk` visits one node at a time, but the left and right subtrees
+    are independent, so they can be reduced in parallel
+ 2. the goroutine budget limits the fan-out:
+    only the top log2(budget) levels spawn goroutines
+ 3. results are combined in-order (left, node, right),
+    so any associative reducer keeps the order of `Walk`
+ 4. `testing.Benchmark` compares it with the sequential `Walk`
+*/
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"golang.org/x/tour/tree"
+)
+
+// Walk walks the tree t sending all values
+// from the tree to the channel ch.
+func Walk(t *tree.Tree, ch chan int) {
+	var walker func(t *tree.Tree)
+	walker = func(t *tree.Tree) {
+		if t == nil {
+			return
+		}
+		walker(t.Left)
+		ch <- t.Value
+		walker(t.Right)
+	}
+	walker(t)
+	close(ch)
+}
+
+// Reduce folds the tree t into a single result.
+// visit turns one value into a result,
+// and combine merges two results and must be associative;
+// the results of a node are combined as (left, node, right).
+// empty is the result of an empty tree.
+// At most budget goroutines (including the caller) do the work.
+func Reduce[R any](t *tree.Tree, budget int, empty R, visit func(v int) R, combine func(a, b R) R) R {
+	// the number of levels that fork a goroutine for their left child;
+	// each level doubles the number of goroutines
+	forkDepth := 0
+	for n := 2; n <= budget; n *= 2 {
+		forkDepth++
+	}
+
+	var reduce func(t *tree.Tree, depth int) R
+	reduce = func(t *tree.Tree, depth int) R {
+		if t == nil {
+			return empty
+		}
+		var left R
+		if depth < forkDepth {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				left = reduce(t.Left, depth+1)
+			}()
+			right := reduce(t.Right, depth+1)
+			wg.Wait()
+			return combine(combine(left, visit(t.Value)), right)
+		}
+		left = reduce(t.Left, depth+1)
+		return combine(combine(left, visit(t.Value)), reduce(t.Right, depth+1))
+	}
+	return reduce(t, 0)
+}
+
+// Sum returns the sum of all values in t.
+func Sum(t *tree.Tree, budget int) int {
+	return Reduce(t, budget, 0,
+		func(v int) int { return v },
+		func(a, b int) int { return a + b })
+}
+
+// Count returns the number of nodes in t.
+func Count(t *tree.Tree, budget int) int {
+	return Reduce(t, budget, 0,
+		func(int) int { return 1 },
+		func(a, b int) int { return a + b })
+}
+
+// bounds is the result of MinMax for a subtree
+type bounds struct {
+	min, max int
+	ok       bool
+}
+
+// MinMax returns the smallest and largest values of t.
+// ok is false if t is empty.
+func MinMax(t *tree.Tree, budget int) (min, max int, ok bool) {
+	b := Reduce(t, budget, bounds{},
+		func(v int) bounds { return bounds{v, v, true} },
+		func(a, b bounds) bounds {
+			if !a.ok {
+				return b
+			}
+			if !b.ok {
+				return a
+			}
+			if b.min < a.min {
+				a.min = b.min
+			}
+			if b.max > a.max {
+				a.max = b.max
+			}
+			return a
+		})
+	return b.min, b.max, b.ok
+}
+
+// Values returns all values of t in the same order as `Walk`.
+func Values(t *tree.Tree, budget int) []int {
+	return Reduce(t, budget, nil,
+		func(v int) []int { return []int{v} },
+		func(a, b []int) []int { return append(a, b...) })
+}
+
+// ParallelWalk sends all values of t to ch and closes it.
+// With budget > 1 the values arrive in no particular order;
+// use Values when the order matters.
+func ParallelWalk(t *tree.Tree, budget int, ch chan int) {
+	Reduce(t, budget, struct{}{},
+		func(v int) struct{} {
+			ch <- v
+			return struct{}{}
+		},
+		func(a, b struct{}) struct{} { return a })
+	close(ch)
+}
+
+// balanced builds a balanced tree holding lo, lo+1, ..., hi-1.
+// (`tree.New` only ever makes trees of 10 nodes.)
+func balanced(lo, hi int) *tree.Tree {
+	if lo >= hi {
+		return nil
+	}
+	mid := lo + (hi-lo)/2
+	return &tree.Tree{Left: balanced(lo, mid), Value: mid, Right: balanced(mid+1, hi)}
+}
+
+func main() {
+	t := tree.New(1)
+	fmt.Println("sum:", Sum(t, 4), "count:", Count(t, 4))
+	min, max, _ := MinMax(t, 4)
+	fmt.Println("min:", min, "max:", max)
+	// [1 2 3 4 5 6 7 8 9 10], whatever the budget
+	fmt.Println("values:", Values(t, 4))
+
+	// unordered: a set of the same values
+	ch := make(chan int)
+	go ParallelWalk(t, 4, ch)
+	seen := 0
+	for range ch {
+		seen++
+	}
+	fmt.Println("walked:", seen)
+
+	// sequential `Walk` vs parallel Sum on a large tree
+	// small trees are faster sequentially: forking costs more than it saves,
+	// and there is no gain at all with a single CPU
+	fmt.Println("GOMAXPROCS:", runtime.GOMAXPROCS(0))
+	for _, n := range []int{100, 10_000, 1_000_000} {
+		big := balanced(0, n)
+		walk := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ch := make(chan int, 1024)
+				go Walk(big, ch)
+				s := 0
+				for v := range ch {
+					s += v
+				}
+			}
+		})
+		fmt.Printf("n=%-8d Walk     %12d ns/op\n", n, walk.NsPerOp())
+		for _, budget := range []int{1, 4, 16} {
+			res := testing.Benchmark(func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					Sum(big, budget)
+				}
+			})
+			fmt.Printf("n=%-8d Sum(%2d)  %12d ns/op\n", n, budget, res.NsPerOp())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ 1. `SafeCounter` serializes every `Inc` on a single mutex
+ 2. `ShardedCounter` splits the keys over several shards,
+    each with its own `sync.RWMutex`
+ 3. the count of a key is an `atomic.Int64`,
+    so increments of an existing key only need the read lock
+ 4. `testing.Benchmark` compares both at different goroutine counts
+*/
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// SafeCounter is safe to use concurrently.
+type SafeCounter struct {
+	mu sync.Mutex
+	v  map[string]int
+}
+
+// Inc increments the counter for the given key.
+func (c *SafeCounter) Inc(key string) {
+	c.mu.Lock()
+	// Lock so only one goroutine at a time can access the map c.v.
+	c.v[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter for the given key.
+func (c *SafeCounter) Value(key string) int {
+	c.mu.Lock()
+	// Lock so only one goroutine at a time can access the map c.v.
+	defer c.mu.Unlock()
+	return c.v[key]
+}
+
+// shard owns the keys that hash to it.
+// The lock only guards the map; the counts are atomics.
+type shard struct {
+	mu sync.RWMutex
+	v  map[string]*atomic.Int64
+}
+
+// ShardedCounter is safe to use concurrently,
+// and goroutines working on different shards never wait for each other.
+type ShardedCounter struct {
+	seed   maphash.Seed
+	shards []shard
+}
+
+// NewShardedCounter returns a counter with n shards (at least 1).
+func NewShardedCounter(n int) *ShardedCounter {
+	if n < 1 {
+		n = 1
+	}
+	c := &ShardedCounter{seed: maphash.MakeSeed(), shards: make([]shard, n)}
+	for i := range c.shards {
+		c.shards[i].v = make(map[string]*atomic.Int64)
+	}
+	return c
+}
+
+func (c *ShardedCounter) shard(key string) *shard {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	h.WriteString(key)
+	return &c.shards[h.Sum64()%uint64(len(c.shards))]
+}
+
+// Inc increments the counter for the given key.
+func (c *ShardedCounter) Inc(key string) {
+	c.Add(key, 1)
+}
+
+// Add adds n to the counter for the given key.
+func (c *ShardedCounter) Add(key string, n int) {
+	s := c.shard(key)
+
+	// fast path: the key exists, a read lock is enough
+	s.mu.RLock()
+	v, ok := s.v[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		// slow path: check again under the write lock,
+		// another goroutine may have added the key in between
+		s.mu.Lock()
+		if v, ok = s.v[key]; !ok {
+			v = new(atomic.Int64)
+			s.v[key] = v
+		}
+		s.mu.Unlock()
+	}
+	v.Add(int64(n))
+}
+
+// Value returns the current value of the counter for the given key.
+func (c *ShardedCounter) Value(key string) int {
+	s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v, ok := s.v[key]; ok {
+		return int(v.Load())
+	}
+	return 0
+}
+
+// Snapshot returns a copy of all counters.
+// Shards are read one after another,
+// so increments running meanwhile may or may not be included.
+func (c *ShardedCounter) Snapshot() map[string]int {
+	m := make(map[string]int)
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		for k, v := range s.v {
+			m[k] = int(v.Load())
+		}
+		s.mu.RUnlock()
+	}
+	return m
+}
+
+// incrementer is what both counters have in common
+type incrementer interface {
+	Inc(key string)
+}
+
+// benchInc runs b.N increments spread over the given number of goroutines.
+// Every goroutine uses one of keys.
+func benchInc(c incrementer, goroutines int, keys []string) func(b *testing.B) {
+	return func(b *testing.B) {
+		var wg sync.WaitGroup
+		per := b.N/goroutines + 1
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				for i := 0; i < per; i++ {
+					c.Inc(key)
+				}
+			}(keys[g%len(keys)])
+		}
+		wg.Wait()
+	}
+}
+
+func main() {
+	c := NewShardedCounter(16)
+
+	// the same 1000 goroutines as in the mutex example,
+	// but waiting on them instead of sleeping
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc("somekey")
+		}()
+	}
+	wg.Wait()
+	c.Add("otherkey", 42)
+
+	// 1000
+	fmt.Println(c.Value("somekey"))
+	// map[otherkey:42 somekey:1000]
+	fmt.Println(c.Snapshot())
+
+	// with a single CPU there is no contention to remove,
+	// and the extra hashing makes the sharded counter slower
+	fmt.Println("GOMAXPROCS:", runtime.GOMAXPROCS(0))
+	hot := []string{"somekey"}
+	var spread []string
+	for i := 0; i < 64; i++ {
+		spread = append(spread, fmt.Sprint("key", i))
+	}
+	for _, goroutines := range []int{1, 8, 64, 1000} {
+		for _, keys := range [][]string{hot, spread} {
+			m := testing.Benchmark(benchInc(&SafeCounter{v: make(map[string]int)}, goroutines, keys))
+			s := testing.Benchmark(benchInc(NewShardedCounter(16), goroutines, keys))
+			fmt.Printf("goroutines=%-5d keys=%-3d mutex %5d ns/op  sharded %5d ns/op\n",
+				goroutines, len(keys), m.NsPerOp(), s.NsPerOp())
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ 1. `SafeCounter` only grows; `WindowCounter` counts the events
+    of the last `window` only
+ 2. the window is split into buckets of `resolution` each,
+    kept in a ring per key; old buckets are reused, not shifted
+ 3. keys without events for `idle` are dropped by `Expire`
+ 4. time comes from a `Clock`, so a fake one makes the output deterministic
+*/
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time. `realClock` uses the time package.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// fakeClock only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+// Nothing waits on this clock, so there are no timers to fire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// bucket counts the events of one slot of `resolution`.
+// slot is the absolute slot number, so a stale bucket is easy to spot.
+type bucket struct {
+	slot  int64
+	count int
+}
+
+// series is the ring of buckets of one key
+type series struct {
+	buckets []bucket
+	last    time.Time // time of the last event
+}
+
+// WindowCounter is safe to use concurrently.
+type WindowCounter struct {
+	mu         sync.Mutex
+	clock      Clock
+	resolution time.Duration
+	buckets    int // per key, so that buckets*resolution covers the window
+	idle       time.Duration
+	v          map[string]*series
+}
+
+// NewWindowCounter returns a counter over the last window,
+// with buckets of resolution; the window is rounded up
+// to a whole number of buckets.
+// Keys without events for idle are removed by Expire.
+// A nil clock means the real time. It panics if window is not positive.
+func NewWindowCounter(window, resolution, idle time.Duration, clock Clock) *WindowCounter {
+	if window <= 0 {
+		panic(fmt.Sprintf("NewWindowCounter: window must be positive, got %v", window))
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	if resolution <= 0 || resolution > window {
+		resolution = window
+	}
+	return &WindowCounter{
+		clock:      clock,
+		resolution: resolution,
+		buckets:    int((window + resolution - 1) / resolution),
+		idle:       idle,
+		v:          make(map[string]*series),
+	}
+}
+
+func (c *WindowCounter) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(c.resolution)
+}
+
+// Inc records one event for the given key.
+func (c *WindowCounter) Inc(key string) {
+	c.Add(key, 1)
+}
+
+// Add records n events for the given key.
+func (c *WindowCounter) Add(key string, n int) {
+	now := c.clock.Now()
+	slot := c.slot(now)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.v[key]
+	if !ok {
+		s = &series{buckets: make([]bucket, c.buckets)}
+		c.v[key] = s
+	}
+	b := s.bucket(slot)
+	// the bucket still holds a slot that has left the window
+	if b.slot != slot {
+		*b = bucket{slot: slot}
+	}
+	b.count += n
+	s.last = now
+}
+
+// Value returns the number of events for the given key
+// in the last window.
+func (c *WindowCounter) Value(key string) int {
+	slot := c.slot(c.clock.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.v[key]
+	if !ok {
+		return 0
+	}
+	return s.sum(slot)
+}
+
+// bucket returns the bucket of the ring that slot goes into.
+// Slots before 1970 are negative, so the index must not be.
+func (s *series) bucket(slot int64) *bucket {
+	n := int64(len(s.buckets))
+	return &s.buckets[(slot%n+n)%n]
+}
+
+// sum adds up the buckets that are still inside the window ending at slot.
+func (s *series) sum(slot int64) int {
+	total := 0
+	for _, b := range s.buckets {
+		if slot-b.slot < int64(len(s.buckets)) {
+			total += b.count
+		}
+	}
+	return total
+}
+
+// Snapshot returns the windowed counts of all keys that have any.
+func (c *WindowCounter) Snapshot() map[string]int {
+	slot := c.slot(c.clock.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int)
+	for k, s := range c.v {
+		if n := s.sum(slot); n > 0 {
+			m[k] = n
+		}
+	}
+	return m
+}
+
+// Expire removes the keys whose last event is older than idle
+// and returns how many were removed.
+func (c *WindowCounter) Expire() int {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for k, s := range c.v {
+		if now.Sub(s.last) >= c.idle {
+			delete(c.v, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of keys currently tracked.
+func (c *WindowCounter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.v)
+}
+
+func main() {
+	clock := &fakeClock{now: time.Date(2022, 9, 3, 16, 0, 0, 0, time.UTC)}
+	// events of the last 10s, in buckets of 1s, forget keys idle for 1m
+	c := NewWindowCounter(10*time.Second, time.Second, time.Minute, clock)
+
+	// one "somekey" per second for 15 seconds
+	for i := 0; i < 15; i++ {
+		c.Inc("somekey")
+		clock.Advance(time.Second)
+	}
+	c.Add("otherkey", 3)
+
+	// the window is the current second and the 9 before it,
+	// so only the events of seconds 6..14 count: 9 3
+	fmt.Println(c.Value("somekey"), c.Value("otherkey"))
+
+	// 5 seconds later, only seconds 11..14 are left: 4
+	clock.Advance(5 * time.Second)
+	fmt.Println(c.Value("somekey"))
+
+	// after another 10 seconds nothing is left: map[]
+	clock.Advance(10 * time.Second)
+	fmt.Println(c.Snapshot())
+
+	// both keys are still kept until they have been idle for a minute
+	// 2 keys, 0 expired
+	fmt.Println(c.Len(), "keys,", c.Expire(), "expired")
+	clock.Advance(time.Minute)
+	// 2 expired, 0 keys
+	fmt.Println(c.Expire(), "expired,", c.Len(), "keys")
+
+	// sorted output of a busier snapshot
+	for i, k := range []string{"a", "b", "c"} {
+		c.Add(k, i+1)
+	}
+	snap := c.Snapshot()
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Println(k, snap[k])
+	}
+
+	// before 1970 the slots are negative, and still land in the ring: 1
+	moon := &fakeClock{now: time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)}
+	old := NewWindowCounter(10*time.Second, time.Second, time.Minute, moon)
+	old.Inc("eagle")
+	fmt.Println(old.Value("eagle"))
+}
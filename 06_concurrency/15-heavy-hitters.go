@@ -0,0 +1,219 @@
+/*
+ 1. an exact `map[string]int` grows with the number of distinct keys
+ 2. a Count-Min Sketch answers `Value` for any key in fixed memory;
+    it never under-counts, and over-counts by at most epsilon*N
+    with probability 1-delta (N = all events so far)
+ 3. a min-heap keeps the k keys with the highest estimates;
+    a new key only gets in by beating the lightest one
+*/
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// sketch is a Count-Min Sketch of depth rows and width columns.
+// Every row hashes with its own seed, so that keys colliding
+// in one row are unlikely to collide in the others.
+type sketch struct {
+	seeds  []maphash.Seed
+	counts [][]int
+}
+
+func newSketch(width, depth int) sketch {
+	s := sketch{seeds: make([]maphash.Seed, depth), counts: make([][]int, depth)}
+	for row := range s.counts {
+		s.seeds[row] = maphash.MakeSeed()
+		s.counts[row] = make([]int, width)
+	}
+	return s
+}
+
+// column returns the column of key in the given row.
+func (s *sketch) column(key string, row int) int {
+	var h maphash.Hash
+	h.SetSeed(s.seeds[row])
+	h.WriteString(key)
+	return int(h.Sum64() % uint64(len(s.counts[row])))
+}
+
+func (s *sketch) add(key string, n int) {
+	for row := range s.counts {
+		s.counts[row][s.column(key, row)] += n
+	}
+}
+
+// estimate is the smallest count over all rows:
+// every row over-counts because of collisions, never under-counts.
+func (s *sketch) estimate(key string) int {
+	min := math.MaxInt
+	for row := range s.counts {
+		if c := s.counts[row][s.column(key, row)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Hitter is one of the heaviest keys.
+// Its true count is between Count-Error and Count
+// (with probability 1-delta).
+type Hitter struct {
+	Key   string
+	Count int
+	Error int
+}
+
+// hitterHeap is a min-heap on Count, so the lightest key is evicted first.
+type hitterHeap struct {
+	items []*Hitter
+	index map[string]int // key -> position in items
+}
+
+func (h *hitterHeap) Len() int           { return len(h.items) }
+func (h *hitterHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+func (h *hitterHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+func (h *hitterHeap) Push(x any) {
+	it := x.(*Hitter)
+	h.index[it.Key] = len(h.items)
+	h.items = append(h.items, it)
+}
+func (h *hitterHeap) Pop() any {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, it.Key)
+	return it
+}
+
+// HeavyHitters is an approximate counter in bounded memory.
+// It is safe to use concurrently.
+type HeavyHitters struct {
+	mu      sync.Mutex
+	epsilon float64
+	total   int
+	cms     sketch
+	k       int
+	top     hitterHeap
+}
+
+// NewHeavyHitters returns a counter whose Value over-counts
+// by at most epsilon*N with probability 1-delta,
+// and which tracks the k heaviest keys.
+// It panics unless epsilon > 0, 0 < delta < 1 and k > 0.
+func NewHeavyHitters(epsilon, delta float64, k int) *HeavyHitters {
+	// written so that NaN fails, too
+	switch {
+	case !(epsilon > 0):
+		panic(fmt.Sprintf("NewHeavyHitters: epsilon must be positive, got %v", epsilon))
+	case !(delta > 0 && delta < 1):
+		panic(fmt.Sprintf("NewHeavyHitters: delta must be in (0, 1), got %v", delta))
+	case k <= 0:
+		panic(fmt.Sprintf("NewHeavyHitters: k must be positive, got %d", k))
+	}
+	width := int(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	return &HeavyHitters{
+		epsilon: epsilon,
+		cms:     newSketch(width, depth),
+		k:       k,
+		top:     hitterHeap{index: make(map[string]int)},
+	}
+}
+
+// Inc increments the counter for the given key.
+func (c *HeavyHitters) Inc(key string) {
+	c.Add(key, 1)
+}
+
+// Add adds n to the counter for the given key.
+// It panics if n is not positive: a sketch cannot take counts back,
+// and Value would no longer be an upper bound.
+func (c *HeavyHitters) Add(key string, n int) {
+	if n <= 0 {
+		panic(fmt.Sprintf("HeavyHitters.Add: n must be positive, got %d", n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += n
+	c.cms.add(key, n)
+	est := c.cms.estimate(key)
+
+	// a tracked key moves up with its new estimate
+	if i, ok := c.top.index[key]; ok {
+		c.top.items[i].Count = est
+		heap.Fix(&c.top, i)
+		return
+	}
+	if c.top.Len() < c.k {
+		heap.Push(&c.top, &Hitter{Key: key, Count: est})
+		return
+	}
+	// otherwise the key replaces the lightest one if it is heavier
+	if min := c.top.items[0]; est > min.Count {
+		delete(c.top.index, min.Key)
+		c.top.index[key] = 0
+		*min = Hitter{Key: key, Count: est}
+		heap.Fix(&c.top, 0)
+	}
+}
+
+// Value returns the estimated count of the given key.
+// It is never below the true count.
+func (c *HeavyHitters) Value(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cms.estimate(key)
+}
+
+// ErrorBound returns epsilon*N, the most Value over-counts by
+// (with probability 1-delta).
+func (c *HeavyHitters) ErrorBound() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(math.Ceil(c.epsilon * float64(c.total)))
+}
+
+// Top returns the tracked keys, heaviest first.
+func (c *HeavyHitters) Top() []Hitter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bound := int(math.Ceil(c.epsilon * float64(c.total)))
+	top := make([]Hitter, len(c.top.items))
+	for i, it := range c.top.items {
+		top[i] = Hitter{Key: it.Key, Count: it.Count, Error: bound}
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Count > top[j].Count })
+	return top
+}
+
+func main() {
+	// 1% error with 99% probability, top 10 keys
+	c := NewHeavyHitters(0.01, 0.01, 10)
+	exact := make(map[string]int)
+
+	// 1,000,000 events over 100,000 keys, a few of them very popular
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.2, 1, 100_000)
+	for i := 0; i < 1_000_000; i++ {
+		key := fmt.Sprint("key", zipf.Uint64())
+		c.Inc(key)
+		exact[key]++
+	}
+
+	fmt.Println("distinct keys:", len(exact), "sketch cells:", len(c.cms.counts)*len(c.cms.counts[0]))
+	fmt.Println("error bound:", c.ErrorBound())
+	fmt.Printf("%-8s %8s %8s %6s\n", "key", "exact", "estimate", "error")
+	for _, h := range c.Top() {
+		fmt.Printf("%-8s %8d %8d %6d\n", h.Key, exact[h.Key], h.Count, h.Error)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ 1. `SafeCounter` lives in one process; `ReplicatedCounter` is one replica
+    of a counter shared by several processes
+ 2. every replica only bumps its own slot (a G-Counter),
+    increments and decrements are kept apart (a PN-Counter)
+ 3. merging takes the max of every slot, so merges are
+    commutative, associative and idempotent:
+    replicas that have seen the same updates agree, in any order
+ 4. `testing/quick` checks the three merge laws on random states
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"testing/quick"
+)
+
+// GCounter is a grow-only counter: replica id -> count of that replica.
+type GCounter map[string]uint64
+
+// Value returns the sum over all replicas.
+func (g GCounter) Value() uint64 {
+	var sum uint64
+	for _, n := range g {
+		sum += n
+	}
+	return sum
+}
+
+// Merge returns a new counter with the max of every replica.
+func (g GCounter) Merge(o GCounter) GCounter {
+	m := make(GCounter, len(g))
+	for id, n := range g {
+		m[id] = n
+	}
+	for id, n := range o {
+		if n > m[id] {
+			m[id] = n
+		}
+	}
+	return m
+}
+
+// PNCounter is two G-Counters, one for increments and one for decrements.
+type PNCounter struct {
+	P GCounter `json:"p,omitempty"`
+	N GCounter `json:"n,omitempty"`
+}
+
+// Value returns increments minus decrements.
+func (c PNCounter) Value() int {
+	return int(c.P.Value()) - int(c.N.Value())
+}
+
+// Merge merges both halves.
+func (c PNCounter) Merge(o PNCounter) PNCounter {
+	return PNCounter{P: c.P.Merge(o.P), N: c.N.Merge(o.N)}
+}
+
+// CounterState is the state exchanged between replicas: key -> counter.
+type CounterState map[string]PNCounter
+
+// Merge returns a new state holding every key of s and o.
+func (s CounterState) Merge(o CounterState) CounterState {
+	m := make(CounterState, len(s))
+	for k, c := range s {
+		m[k] = c.Merge(PNCounter{})
+	}
+	for k, c := range o {
+		m[k] = m[k].Merge(c)
+	}
+	return m
+}
+
+// Equal reports whether s and o hold the same counts,
+// treating a missing slot like a zero one.
+func (s CounterState) Equal(o CounterState) bool {
+	norm := func(s CounterState) map[string]uint64 {
+		m := make(map[string]uint64)
+		for k, c := range s {
+			for id, n := range c.P {
+				if n != 0 {
+					m[k+"/p/"+id] = n
+				}
+			}
+			for id, n := range c.N {
+				if n != 0 {
+					m[k+"/n/"+id] = n
+				}
+			}
+		}
+		return m
+	}
+	return reflect.DeepEqual(norm(s), norm(o))
+}
+
+// Generate makes random states for `testing/quick`,
+// over a few keys and replicas so that they overlap.
+func (CounterState) Generate(r *rand.Rand, size int) reflect.Value {
+	keys := []string{"a", "b", "c"}
+	ids := []string{"x", "y", "z"}
+	s := make(CounterState)
+	for i := r.Intn(size + 1); i > 0; i-- {
+		k, id := keys[r.Intn(len(keys))], ids[r.Intn(len(ids))]
+		c := s[k].Merge(PNCounter{})
+		if r.Intn(2) == 0 {
+			c.P[id] = uint64(r.Intn(100))
+		} else {
+			c.N[id] = uint64(r.Intn(100))
+		}
+		s[k] = c
+	}
+	return reflect.ValueOf(s)
+}
+
+// ReplicatedCounter is one replica of a counter shared between processes.
+// It is safe to use concurrently.
+type ReplicatedCounter struct {
+	mu    sync.Mutex
+	id    string
+	state CounterState
+}
+
+// NewReplicatedCounter returns a replica with the given unique id.
+func NewReplicatedCounter(id string) *ReplicatedCounter {
+	return &ReplicatedCounter{id: id, state: make(CounterState)}
+}
+
+// Inc increments the counter for the given key.
+func (c *ReplicatedCounter) Inc(key string) {
+	c.Add(key, 1)
+}
+
+// Add adds n (which may be negative) to the counter for the given key.
+func (c *ReplicatedCounter) Add(key string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// merging with an empty counter makes sure both maps exist
+	pn := c.state[key].Merge(PNCounter{})
+	if n >= 0 {
+		pn.P[c.id] += uint64(n)
+	} else {
+		pn.N[c.id] += uint64(-n)
+	}
+	c.state[key] = pn
+}
+
+// Value returns the current value of the counter for the given key.
+func (c *ReplicatedCounter) Value(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state[key].Value()
+}
+
+// Snapshot returns the values of all keys.
+func (c *ReplicatedCounter) Snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int, len(c.state))
+	for k, pn := range c.state {
+		m[k] = pn.Value()
+	}
+	return m
+}
+
+// State returns the current state, to be merged into other replicas.
+func (c *ReplicatedCounter) State() CounterState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Merge(nil)
+}
+
+// Merge merges the state of another replica into this one.
+func (c *ReplicatedCounter) Merge(s CounterState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = c.state.Merge(s)
+}
+
+// MarshalJSON encodes the state of the replica.
+func (c *ReplicatedCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.State())
+}
+
+// MergeJSON merges a state encoded by MarshalJSON.
+func (c *ReplicatedCounter) MergeJSON(data []byte) error {
+	var s CounterState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	c.Merge(s)
+	return nil
+}
+
+func main() {
+	// three instances of a service, each counting on its own
+	replicas := []*ReplicatedCounter{
+		NewReplicatedCounter("x"),
+		NewReplicatedCounter("y"),
+		NewReplicatedCounter("z"),
+	}
+	var wg sync.WaitGroup
+	for i, r := range replicas {
+		wg.Add(1)
+		go func(i int, r *ReplicatedCounter) {
+			defer wg.Done()
+			for j := 0; j < 100*(i+1); j++ {
+				r.Inc("somekey")
+			}
+			r.Add("otherkey", -(i + 1))
+		}(i, r)
+	}
+	wg.Wait()
+
+	// local views differ: 100 200 300
+	fmt.Println(replicas[0].Value("somekey"), replicas[1].Value("somekey"), replicas[2].Value("somekey"))
+
+	// gossip: every replica sends its JSON state to every other one,
+	// some of them twice, in a different order each time
+	for _, to := range []int{2, 0, 1, 1, 2} {
+		for _, r := range replicas {
+			data, err := r.MarshalJSON()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if err := replicas[to].MergeJSON(data); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	}
+
+	// all replicas converge: otherkey=-6 somekey=600
+	for _, r := range replicas {
+		snap := r.Snapshot()
+		keys := make([]string, 0, len(snap))
+		for k := range snap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Print(r.id, ":")
+		for _, k := range keys {
+			fmt.Printf(" %s=%d", k, snap[k])
+		}
+		fmt.Println()
+	}
+
+	// the merge laws, on random states
+	laws := []struct {
+		name string
+		f    any
+	}{
+		{"commutative", func(a, b CounterState) bool {
+			return a.Merge(b).Equal(b.Merge(a))
+		}},
+		{"associative", func(a, b, c CounterState) bool {
+			return a.Merge(b).Merge(c).Equal(a.Merge(b.Merge(c)))
+		}},
+		{"idempotent", func(a CounterState) bool {
+			return a.Merge(a).Equal(a)
+		}},
+	}
+	for _, law := range laws {
+		err := quick.Check(law.f, &quick.Config{MaxCount: 1000})
+		fmt.Printf("%s: ok=%v\n", law.name, err == nil)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+/*
+ 1. `SafeCounter` behind a small HTTP API
+    GET    /counters        all counters as JSON
+    GET    /counters/{key}  the value of one key
+    POST   /counters/{key}  increment by ?n= (default 1)
+    DELETE /counters/{key}  reset one key
+ 2. every change is appended to a log file before it is applied,
+    and a periodic snapshot starts a new log; the snapshot names its log
+    by a generation number, so a crash in the middle of a snapshot
+    never replays an entry twice
+ 3. on start the snapshot is loaded and its log replayed,
+    so counts survive restarts
+ 4. `go run 17-counter-server.go -selftest` hammers the API concurrently,
+    restarts from disk and checks that no increment was lost
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	maxKeyLen    = 256
+)
+
+// logFile returns the name of the log that follows the snapshot of generation gen
+func logFile(gen int) string {
+	return fmt.Sprintf("counters-%d.log", gen)
+}
+
+// snapshot is the content of the snapshot file
+type snapshot struct {
+	Gen      int            `json:"gen"`
+	Counters map[string]int `json:"counters"`
+}
+
+// entry is one line of the append-only log
+type entry struct {
+	Key   string `json:"key"`
+	N     int    `json:"n,omitempty"`
+	Reset bool   `json:"reset,omitempty"`
+}
+
+// SafeCounter is safe to use concurrently,
+// and writes every change to disk before applying it.
+type SafeCounter struct {
+	mu  sync.Mutex
+	v   map[string]int
+	dir string
+	gen int // generation of the last snapshot, and of the log
+	log *os.File
+}
+
+// OpenSafeCounter loads the counters stored in dir
+// (creating it if needed) and opens the log for appending.
+func OpenSafeCounter(dir string) (*SafeCounter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &SafeCounter{v: make(map[string]int), dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err == nil {
+		snap := snapshot{Counters: c.v}
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		c.gen = snap.Gen
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	c.log, err = c.openLog(c.gen)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.replay(c.log); err != nil {
+		c.log.Close()
+		return nil, fmt.Errorf("replaying log: %w", err)
+	}
+	// the logs of other generations are left over from a crash during Save
+	if err := c.removeLogs(); err != nil {
+		c.log.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SafeCounter) openLog(gen int) (*os.File, error) {
+	return os.OpenFile(filepath.Join(c.dir, logFile(gen)), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+}
+
+// removeLogs removes all logs but the one of the current generation.
+func (c *SafeCounter) removeLogs() error {
+	logs, err := filepath.Glob(filepath.Join(c.dir, "counters-*.log"))
+	if err != nil {
+		return err
+	}
+	for _, l := range logs {
+		if filepath.Base(l) != logFile(c.gen) {
+			if err := os.Remove(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replay applies every entry of the log on top of the snapshot.
+// A torn last line (a crash in the middle of a write) is ignored.
+// Lines are read whole, however long the key.
+func (c *SafeCounter) replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		var e entry
+		if len(line) > 0 && json.Unmarshal(line, &e) == nil {
+			c.apply(e)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *SafeCounter) apply(e entry) {
+	if e.Reset {
+		delete(c.v, e.Key)
+		return
+	}
+	c.v[e.Key] += e.N
+}
+
+// record logs e and then applies it, under the lock,
+// so the log has the same order as the changes.
+func (c *SafeCounter) record(e entry) (int, error) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.log.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	// on disk, not just in the page cache, before the change is visible
+	if err := c.log.Sync(); err != nil {
+		return 0, err
+	}
+	c.apply(e)
+	return c.v[e.Key], nil
+}
+
+// Inc increments the counter for the given key.
+func (c *SafeCounter) Inc(key string) error {
+	_, err := c.Add(key, 1)
+	return err
+}
+
+// Add adds n to the counter for the given key and returns the new value.
+func (c *SafeCounter) Add(key string, n int) (int, error) {
+	return c.record(entry{Key: key, N: n})
+}
+
+// Reset removes the counter for the given key.
+func (c *SafeCounter) Reset(key string) error {
+	_, err := c.record(entry{Key: key, Reset: true})
+	return err
+}
+
+// Value returns the current value of the counter for the given key.
+func (c *SafeCounter) Value(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v[key]
+}
+
+// Snapshot returns a copy of all counters.
+func (c *SafeCounter) Snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int, len(c.v))
+	for k, v := range c.v {
+		m[k] = v
+	}
+	return m
+}
+
+// Save writes a snapshot of the next generation and starts its empty log.
+// A crash before the snapshot is renamed into place leaves the old snapshot
+// and the old log, a crash after it the new snapshot and the new log:
+// either way every entry is counted once.
+func (c *SafeCounter) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next, err := c.openLog(c.gen + 1)
+	if err != nil {
+		return err
+	}
+	if err := c.writeSnapshot(c.gen + 1); err != nil {
+		next.Close()
+		return err
+	}
+	// only now the logged entries are part of the snapshot
+	old := c.log
+	c.log, c.gen = next, c.gen+1
+	old.Close()
+	return os.Remove(filepath.Join(c.dir, logFile(c.gen-1)))
+}
+
+// writeSnapshot writes the counters as the snapshot of generation gen.
+// It is written to a temporary file and renamed,
+// so a crash leaves either the old or the new one.
+func (c *SafeCounter) writeSnapshot(gen int) error {
+	data, err := json.Marshal(snapshot{gen, c.v})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, snapshotFile))
+}
+
+// Close saves a last snapshot and closes the log.
+func (c *SafeCounter) Close() error {
+	err := c.Save()
+	if cerr := c.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// counterHandler serves the HTTP API of a SafeCounter
+type counterHandler struct {
+	c *SafeCounter
+}
+
+func (h *counterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/counters")
+	key = strings.TrimPrefix(key, "/")
+
+	if key == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, h.c.Snapshot())
+		return
+	}
+
+	if len(key) > maxKeyLen {
+		http.Error(w, "key too long", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"key": key, "value": h.c.Value(key)})
+	case http.MethodPost:
+		n := 1
+		if s := r.URL.Query().Get("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "n must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+		v, err := h.c.Add(key, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"key": key, "value": v})
+	case http.MethodDelete:
+		if err := h.c.Reset(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("writing response: ", err)
+	}
+}
+
+func newMux(c *SafeCounter) *http.ServeMux {
+	mux := http.NewServeMux()
+	h := &counterHandler{c}
+	mux.Handle("/counters", h)
+	mux.Handle("/counters/", h)
+	return mux
+}
+
+// saveEvery saves a snapshot at every tick until done is closed.
+func saveEvery(c *SafeCounter, interval time.Duration, done <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := c.Save(); err != nil {
+				log.Print("snapshot: ", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// selftest runs concurrent POSTs against a server on a temporary directory,
+// then reopens the directory and compares the counts.
+func selftest() error {
+	dir, err := os.MkdirTemp("", "counters")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := OpenSafeCounter(dir)
+	if err != nil {
+		return err
+	}
+	srv := httptest.NewServer(newMux(c))
+
+	// snapshots run in the middle of the load, too
+	done := make(chan struct{})
+	go saveEvery(c, 5*time.Millisecond, done)
+
+	const workers, requests = 50, 100
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				resp, err := http.Post(srv.URL+"/counters/somekey", "", nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	srv.Close()
+	close(errs)
+	for err := range errs {
+		return err
+	}
+
+	fmt.Println("served:", c.Value("somekey"))
+	// simulate a crash: close the log without a final snapshot
+	c.log.Close()
+
+	c, err = OpenSafeCounter(dir)
+	if err != nil {
+		return err
+	}
+	got := c.Value("somekey")
+	fmt.Println("after restart:", got)
+	if got != workers*requests {
+		return fmt.Errorf("lost %d increments", workers*requests-got)
+	}
+
+	// simulate a crash in the middle of Save: the new snapshot is in place,
+	// but the old log was not removed yet
+	c.Inc("somekey")
+	c.mu.Lock()
+	err = c.writeSnapshot(c.gen + 1)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.log.Close()
+
+	c, err = OpenSafeCounter(dir)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	got = c.Value("somekey")
+	fmt.Println("after a crash during a snapshot:", got)
+	if got != workers*requests+1 {
+		return fmt.Errorf("counted %d instead of %d", got, workers*requests+1)
+	}
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	// outside the source tree, so a plain `go run` leaves nothing behind
+	dir := flag.String("data", filepath.Join(os.TempDir(), "counters-data"), "directory for the snapshot and the log")
+	every := flag.Duration("snapshot", 10*time.Second, "interval between snapshots")
+	test := flag.Bool("selftest", false, "run a concurrent load test and exit")
+	flag.Parse()
+
+	if *test {
+		if err := selftest(); err != nil {
+			log.Fatal("selftest: ", err)
+		}
+		fmt.Println("selftest: ok")
+		return
+	}
+
+	c, err := OpenSafeCounter(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &http.Server{Addr: *addr, Handler: newMux(c)}
+
+	stop := make(chan struct{})
+	go saveEvery(c, *every, stop)
+
+	// the same shutdown as in the hello world server
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-done
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Print("Shutdown server: ", err)
+		}
+	}()
+
+	log.Println("Starting counter server on", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	close(stop)
+	if err := c.Close(); err != nil {
+		log.Fatal("saving counters: ", err)
+	}
+	log.Print("Server closed, counters saved")
+}
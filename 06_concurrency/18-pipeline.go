@@ -0,0 +1,337 @@
+/*
+ 1. the split/combine of `sum` in `2-channels.go`, made reusable:
+    Source -> FanOut -> Map -> Merge -> Filter -> Batch -> Reduce
+ 2. every stage is a goroutine that closes its output channel when done
+ 3. every send and receive also selects on `ctx.Done()`,
+    so cancelling the context stops all stages
+ 4. the first error of any stage cancels the whole pipeline
+    and is returned by `Reduce` (or `Wait`)
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Pipeline tracks the goroutines of all stages and the first error.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+// NewPipeline returns a pipeline that stops when ctx is done.
+func NewPipeline(ctx context.Context) *Pipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pipeline{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context shared by all stages.
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// fail records the first error and cancels all stages.
+func (p *Pipeline) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+// stage runs f in its own goroutine.
+func (p *Pipeline) stage(f func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := f(p.ctx); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// Wait waits for all stages and returns the first error,
+// or the context error if the pipeline was cancelled from outside.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.once.Do(func() {
+		p.err = p.ctx.Err()
+	})
+	p.cancel()
+	return p.err
+}
+
+// send sends v on out unless ctx is done first.
+func send[T any](ctx context.Context, out chan<- T, v T) error {
+	select {
+	case out <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recv receives a value from in.
+// ok is false once in is closed, or once ctx is done.
+func recv[T any](ctx context.Context, in <-chan T) (v T, ok bool) {
+	select {
+	case v, ok = <-in:
+		return v, ok
+	case <-ctx.Done():
+		return v, false
+	}
+}
+
+// Source sends items one by one.
+func Source[T any](p *Pipeline, items ...T) <-chan T {
+	out := make(chan T)
+	p.stage(func(ctx context.Context) error {
+		defer close(out)
+		for _, v := range items {
+			if send(ctx, out, v) != nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// Map sends f(v) for every v received from in.
+// An error from f stops the pipeline.
+func Map[T, U any](p *Pipeline, in <-chan T, f func(ctx context.Context, v T) (U, error)) <-chan U {
+	out := make(chan U)
+	p.stage(func(ctx context.Context) error {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return nil
+			}
+			u, err := f(ctx, v)
+			if err != nil {
+				return err
+			}
+			if send(ctx, out, u) != nil {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// Filter only passes on the values for which keep returns true.
+func Filter[T any](p *Pipeline, in <-chan T, keep func(v T) bool) <-chan T {
+	out := make(chan T)
+	p.stage(func(ctx context.Context) error {
+		defer close(out)
+		for {
+			v, ok := recv(ctx, in)
+			if !ok {
+				return nil
+			}
+			if !keep(v) {
+				continue
+			}
+			if send(ctx, out, v) != nil {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// FanOut splits in over n channels.
+// Each value goes to whichever channel is read first.
+// It panics if n is less than 1, since nothing would read from in.
+func FanOut[T any](p *Pipeline, in <-chan T, n int) []<-chan T {
+	if n < 1 {
+		panic("FanOut: n must be at least 1")
+	}
+	outs := make([]<-chan T, n)
+	for i := range outs {
+		out := make(chan T)
+		outs[i] = out
+		p.stage(func(ctx context.Context) error {
+			defer close(out)
+			for {
+				v, ok := recv(ctx, in)
+				if !ok {
+					return nil
+				}
+				if send(ctx, out, v) != nil {
+					return nil
+				}
+			}
+		})
+	}
+	return outs
+}
+
+// Merge sends every value of all ins on one channel.
+func Merge[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		in := in
+		wg.Add(1)
+		p.stage(func(ctx context.Context) error {
+			defer wg.Done()
+			for {
+				v, ok := recv(ctx, in)
+				if !ok {
+					return nil
+				}
+				if send(ctx, out, v) != nil {
+					return nil
+				}
+			}
+		})
+	}
+	// close out once every input is done
+	p.stage(func(ctx context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+// Batch groups values into slices of size,
+// or fewer if timeout passes before a batch is full.
+// It panics if size is less than 1.
+func Batch[T any](p *Pipeline, in <-chan T, size int, timeout time.Duration) <-chan []T {
+	if size < 1 {
+		panic("Batch: size must be at least 1")
+	}
+	out := make(chan []T)
+	p.stage(func(ctx context.Context) error {
+		defer close(out)
+		var batch []T
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			err := send(ctx, out, batch)
+			batch = nil
+			return err
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return nil
+				}
+				if len(batch) == 0 {
+					// the timeout counts from the first value of a batch
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(timeout)
+				}
+				batch = append(batch, v)
+				if len(batch) == size && flush() != nil {
+					return nil
+				}
+			case <-timer.C:
+				if flush() != nil {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// Reduce folds all values of in into one result,
+// then waits for the pipeline and returns its first error.
+func Reduce[T, R any](p *Pipeline, in <-chan T, init R, f func(acc R, v T) R) (R, error) {
+	acc := init
+loop:
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				break loop
+			}
+			acc = f(acc, v)
+		case <-p.ctx.Done():
+			break loop
+		}
+	}
+	if err := p.Wait(); err != nil {
+		var zero R
+		return zero, err
+	}
+	return acc, nil
+}
+
+// slowly returns v after a short sleep, like `sum` in `2-channels.go`
+func slowly(ctx context.Context, v int) (int, error) {
+	select {
+	case <-time.After(10 * time.Millisecond):
+		return v, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func main() {
+	s := []int{7, 2, 8, -9, 4, 0}
+	add := func(acc, v int) int { return acc + v }
+
+	// the sum of `2-channels.go`, over two workers: 12
+	p := NewPipeline(context.Background())
+	workers := FanOut(p, Source(p, s...), 2)
+	partial := make([]<-chan int, len(workers))
+	for i, w := range workers {
+		partial[i] = Map(p, w, slowly)
+	}
+	total, err := Reduce(p, Merge(p, partial...), 0, add)
+	fmt.Println(total, err)
+
+	// only the positive values, in batches of 4: [7 2 8 4] [1 2 3]
+	p = NewPipeline(context.Background())
+	positive := Filter(p, Source(p, 7, 2, 8, -9, 4, 0, 1, 2, 3), func(v int) bool { return v > 0 })
+	batches, err := Reduce(p, Batch(p, positive, 4, time.Second), nil, func(acc [][]int, b []int) [][]int {
+		return append(acc, b)
+	})
+	fmt.Println(batches, err)
+
+	// an error in one stage stops all of them: 0 negative value -9
+	before := runtime.NumGoroutine()
+	p = NewPipeline(context.Background())
+	checked := Map(p, Source(p, s...), func(ctx context.Context, v int) (int, error) {
+		if v < 0 {
+			return 0, fmt.Errorf("negative value %d", v)
+		}
+		return slowly(ctx, v)
+	})
+	total, err = Reduce(p, checked, 0, add)
+	fmt.Println(total, err)
+
+	// so does a cancelled context: 0 true
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+	p = NewPipeline(ctx)
+	total, err = Reduce(p, Map(p, Source(p, s...), slowly), 0, add)
+	fmt.Println(total, errors.Is(err, context.DeadlineExceeded))
+
+	// no stage is left behind: true
+	fmt.Println(runtime.NumGoroutine() <= before)
+}
@@ -0,0 +1,185 @@
+/*
+ 1. `sum` in `2-channels.go`, for any number type and any number of goroutines
+ 2. the slice is cut into blocks of a fixed size, and GOMAXPROCS workers
+    take blocks one after another
+ 3. each block is summed pairwise, and the block sums are combined pairwise
+    in block order, so a float result never depends on the number of
+    workers or on which one finished first
+ 4. `testing.Benchmark` shows from which length it beats a plain loop
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// Number is any integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// blockSize is the unit of work of one goroutine.
+// It is fixed, not derived from GOMAXPROCS, to keep float sums deterministic.
+const blockSize = 4096
+
+// pairwise sums s by halves down to runs of 128 values;
+// its rounding error grows with log(n)
+// instead of n for a plain loop.
+func pairwise[T Number](s []T) T {
+	if len(s) <= 128 {
+		var sum T
+		for _, v := range s {
+			sum += v
+		}
+		return sum
+	}
+	mid := len(s) / 2
+	return pairwise(s[:mid]) + pairwise(s[mid:])
+}
+
+// blocks applies f to every block of s with up to GOMAXPROCS goroutines
+// and returns the results in block order.
+func blocks[T Number, R any](s []T, f func(block []T) R) []R {
+	n := (len(s) + blockSize - 1) / blockSize
+	results := make([]R, n)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+
+	// the indexes of the blocks to do, shared by all workers
+	next := make(chan int, n)
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				end := (i + 1) * blockSize
+				if end > len(s) {
+					end = len(s)
+				}
+				results[i] = f(s[i*blockSize : end])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// ParallelSum returns the sum of s.
+func ParallelSum[T Number](s []T) T {
+	return pairwise(blocks(s, pairwise[T]))
+}
+
+// ParallelMin returns the smallest value of s.
+// ok is false if s is empty.
+func ParallelMin[T Number](s []T) (min T, ok bool) {
+	return extreme(s, func(a, b T) bool { return a < b })
+}
+
+// ParallelMax returns the largest value of s.
+// ok is false if s is empty.
+func ParallelMax[T Number](s []T) (max T, ok bool) {
+	return extreme(s, func(a, b T) bool { return a > b })
+}
+
+// extreme returns the value of s that is better than all others.
+// NaNs are skipped, unless there is nothing else.
+func extreme[T Number](s []T, better func(a, b T) bool) (T, bool) {
+	if len(s) == 0 {
+		var zero T
+		return zero, false
+	}
+	pick := func(block []T) T {
+		best := block[0]
+		for _, v := range block[1:] {
+			if better(v, best) || best != best {
+				best = v
+			}
+		}
+		return best
+	}
+	return pick(blocks(s, pick)), true
+}
+
+// ParallelMean returns the mean of s, or NaN if s is empty.
+// The sum is done in float64, so integer sums do not overflow.
+func ParallelMean[T Number](s []T) float64 {
+	if len(s) == 0 {
+		return math.NaN()
+	}
+	sums := blocks(s, func(block []T) float64 {
+		f := make([]float64, len(block))
+		for i, v := range block {
+			f[i] = float64(v)
+		}
+		return pairwise(f)
+	})
+	return pairwise(sums) / float64(len(s))
+}
+
+// sequentialSum is the plain loop of `sum`.
+func sequentialSum[T Number](s []T) T {
+	var sum T
+	for _, v := range s {
+		sum += v
+	}
+	return sum
+}
+
+func main() {
+	s := []int{7, 2, 8, -9, 4, 0}
+	// 12
+	fmt.Println(ParallelSum(s))
+	min, _ := ParallelMin(s)
+	max, _ := ParallelMax(s)
+	// -9 8 2
+	fmt.Println(min, max, ParallelMean(s))
+
+	// floats: the same bits whatever GOMAXPROCS is
+	f := make([]float64, 1_000_000)
+	for i := range f {
+		f[i] = 1 / float64(i+1)
+	}
+	procs := runtime.GOMAXPROCS(0)
+	for _, n := range []int{1, 2, 8} {
+		runtime.GOMAXPROCS(n)
+		fmt.Printf("GOMAXPROCS=%d sum=%.17g\n", n, ParallelSum(f))
+	}
+	runtime.GOMAXPROCS(procs)
+	// the plain loop collects more rounding error
+	fmt.Printf("sequential   sum=%.17g\n", sequentialSum(f))
+
+	// where the parallel version starts to pay off
+	// (it never does with GOMAXPROCS=1)
+	fmt.Println("GOMAXPROCS:", procs)
+	for _, n := range []int{1_000, 10_000, 100_000, 1_000_000, 10_000_000} {
+		data := make([]float64, n)
+		for i := range data {
+			data[i] = float64(i)
+		}
+		seq := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sequentialSum(data)
+			}
+		})
+		par := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ParallelSum(data)
+			}
+		})
+		fmt.Printf("n=%-9d loop %10d ns/op  parallel %10d ns/op\n", n, seq.NsPerOp(), par.NsPerOp())
+	}
+}
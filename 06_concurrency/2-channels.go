@@ -5,12 +5,22 @@ import (
 	"time"
 )
 
-func sum(s []int, c chan int) {
+// Clock is the part of the time package sum uses,
+// so a fake clock can stand in for it (see 25-virtual-clock.go).
+type Clock interface {
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func sum(clock Clock, s []int, c chan int) {
 	sum := 0
 	for _, v := range s {
 		// introduce some "sleep"
 		// and then observe the "swap" between `x` and `y`
-		time.Sleep(100 * time.Millisecond)
+		clock.Sleep(100 * time.Millisecond)
 		sum += v
 	}
 	c <- sum // send sum to c
@@ -21,8 +31,8 @@ func main() {
 
 	// make a channel of int
 	c := make(chan int)
-	go sum(s[:len(s)/2], c)
-	go sum(s[len(s)/2:], c)
+	go sum(realClock{}, s[:len(s)/2], c)
+	go sum(realClock{}, s[len(s)/2:], c)
 	x, y := <-c, <-c // receive from c
 
 	// sometimes "17 -5 12", and sometimes "-5 17 12"
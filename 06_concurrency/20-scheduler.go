@@ -0,0 +1,583 @@
+/*
+ 1. the `tick`/`boom` select loop of `6-default.go`, made reusable:
+    every job has a loop that waits on a timer or on its stop channel
+ 2. a `Schedule` says when a job runs next:
+    `Every` (fixed interval), `Once` (one-shot) or `Cron` (cron expression)
+ 3. the loop hands runs to a runner goroutine over a channel;
+    `Skip` drops runs while one is going on,
+    `Queue` buffers them and never blocks the loop
+ 4. time comes from a `Clock`; the fake one is advanced by hand,
+    so the example below never sleeps
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of `time.Timer` the scheduler needs.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d and fires the timers
+// that are due, earliest (then oldest) first,
+// with the clock at the time of each timer while it fires.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	// the timers are kept in order of creation, so a stable sort breaks ties
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.when
+		t.c <- t.when
+	}
+	c.now = end
+}
+
+// BlockUntil waits until n timers are waiting,
+// i.e. until the job loops have armed their next timer.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Schedule returns the next time after t a job should run,
+// or the zero time if it should not run anymore.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type every time.Duration
+
+// Every runs a job every d, starting d from now.
+// It panics if d is not positive, like time.NewTicker.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("Every: non-positive interval")
+	}
+	return every(d)
+}
+
+func (e every) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+
+type once time.Time
+
+// Once runs a job a single time, at.
+func Once(at time.Time) Schedule { return once(at) }
+
+func (o once) Next(t time.Time) time.Time {
+	if at := time.Time(o); at.After(t) {
+		return at
+	}
+	return time.Time{}
+}
+
+// cron holds the allowed values of every field as bit sets.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// with both day fields restricted, either one may match (like cron(8))
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Cron parses a standard five-field cron expression
+// "minute hour day-of-month month day-of-week".
+// Fields accept `*`, numbers, ranges `a-b`, steps `/n` and lists `a,b`;
+// `@hourly`, `@daily`, `@weekly`, `@monthly` and `@yearly` are also accepted.
+func Cron(expr string) (Schedule, error) {
+	if m, ok := cronMacros[expr]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: %q: want 5 fields, got %d", expr, len(fields))
+	}
+	var c cron
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if *sets[i], err = parseCronField(f, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", expr, err)
+		}
+	}
+	// 7 is Sunday, too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step := min, max, 1
+		rng := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng = part[:i]
+		}
+		if rng != "*" {
+			var err error
+			if i := strings.Index(rng, "-"); i >= 0 {
+				lo, err = strconv.Atoi(rng[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(rng[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(rng)
+				hi = lo
+				// "5/10" means from 5 to the end in steps of 10
+				if strings.Contains(part, "/") {
+					hi = max
+				}
+			}
+			if err != nil || lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("bad range %q (allowed %d-%d)", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next finds the next matching minute, skipping whole months,
+// days and hours that cannot match.
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// a schedule like "0 0 30 2 *" never matches; give up after 5 years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		loc := t.Location()
+		switch {
+		case c.month&(1<<m) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Overlap says what happens when a run is due while the last one still runs.
+type Overlap int
+
+const (
+	// Skip drops the run.
+	Skip Overlap = iota
+	// Queue runs it once the last one is done, up to QueueLimit pending runs.
+	Queue
+)
+
+func (o Overlap) String() string {
+	if o == Queue {
+		return "Queue"
+	}
+	return "Skip"
+}
+
+// QueueLimit is the number of pending runs a Queue job keeps.
+const QueueLimit = 16
+
+// Option configures a job.
+type Option func(*job)
+
+// WithJitter delays every run by a random duration in [0, d).
+func WithJitter(d time.Duration) Option {
+	return func(j *job) { j.jitter = d }
+}
+
+// WithOverlap sets the overlap policy (default Skip).
+func WithOverlap(o Overlap) Option {
+	return func(j *job) { j.overlap = o }
+}
+
+type job struct {
+	schedule Schedule
+	fn       func(ctx context.Context)
+	jitter   time.Duration
+	overlap  Overlap
+	runs     chan struct{} // to the runner
+	stop     chan struct{} // closed by Remove or Stop, whichever comes first
+	busy     atomic.Bool   // a run is pending or going on (Skip only)
+	stopOnce sync.Once
+
+	mu      sync.Mutex
+	ran     int
+	skipped int
+}
+
+// Scheduler runs jobs on their schedules.
+type Scheduler struct {
+	clock Clock
+	ctx   context.Context // passed to running jobs, cancelled by Stop
+	abort context.CancelFunc
+
+	mu      sync.Mutex
+	jobs    map[int]*job
+	nextID  int
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// ErrStopped is returned by Add after Stop.
+var ErrStopped = errors.New("scheduler: stopped")
+
+// NewScheduler returns a scheduler using clock (nil means the real time).
+func NewScheduler(clock Clock) *Scheduler {
+	if clock == nil {
+		clock = realClock{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{clock: clock, ctx: ctx, abort: cancel, jobs: make(map[int]*job)}
+}
+
+// Add starts running fn on the schedule s and returns the id of the job.
+func (s *Scheduler) Add(sched Schedule, fn func(ctx context.Context), opts ...Option) (int, error) {
+	j := &job{schedule: sched, fn: fn, stop: make(chan struct{})}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.overlap == Queue {
+		j.runs = make(chan struct{}, QueueLimit)
+	} else {
+		// room for the one run that busy lets through
+		j.runs = make(chan struct{}, 1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return 0, ErrStopped
+	}
+	s.nextID++
+	s.jobs[s.nextID] = j
+	s.wg.Add(2)
+	go s.loop(j)
+	go s.runner(j)
+	return s.nextID, nil
+}
+
+// loop waits for the next run of j, like the select loop of `6-default.go`.
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+	defer close(j.runs)
+	// the next run is computed from when the last one was due,
+	// so a slow loop does not make the schedule drift
+	last := s.clock.Now()
+	for {
+		next := j.schedule.Next(last)
+		if next.IsZero() {
+			return
+		}
+		due := next
+		if j.jitter > 0 {
+			due = due.Add(time.Duration(rand.Int63n(int64(j.jitter))))
+		}
+		timer := s.clock.NewTimer(due.Sub(s.clock.Now()))
+		select {
+		case <-timer.C():
+			last = next
+			if !j.hand() {
+				j.mu.Lock()
+				j.skipped++
+				j.mu.Unlock()
+			}
+		case <-j.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// hand passes a run to the runner without blocking
+// and reports whether it was accepted.
+func (j *job) hand() bool {
+	if j.overlap == Skip {
+		if !j.busy.CompareAndSwap(false, true) {
+			return false
+		}
+		j.runs <- struct{}{}
+		return true
+	}
+	select {
+	case j.runs <- struct{}{}:
+		return true
+	default:
+		// the queue is full
+		return false
+	}
+}
+
+// cancel stops the loop of j; it may be called more than once.
+func (j *job) cancel() {
+	j.stopOnce.Do(func() { close(j.stop) })
+}
+
+// runner runs j for every run handed over by loop, one at a time.
+func (s *Scheduler) runner(j *job) {
+	defer s.wg.Done()
+	for range j.runs {
+		j.fn(s.ctx)
+		j.mu.Lock()
+		j.ran++
+		j.mu.Unlock()
+		j.busy.Store(false)
+	}
+}
+
+// Remove stops scheduling the job with the given id.
+// A run that is going on is not interrupted.
+func (s *Scheduler) Remove(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if ok {
+		j.cancel()
+		delete(s.jobs, id)
+	}
+	return ok
+}
+
+// Stats returns how often the job has run and how many runs were skipped.
+func (s *Scheduler) Stats(id int) (ran, skipped int) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return 0, 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.ran, j.skipped
+}
+
+// Stop stops scheduling new runs and waits for the running (and queued) ones.
+// If ctx is done first, the context of the running jobs is cancelled
+// and ctx.Err() is returned at once; jobs that ignore their context
+// are left to finish on their own.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		// the jobs stay, for Stats
+		for _, j := range s.jobs {
+			j.cancel()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.abort()
+		return nil
+	case <-ctx.Done():
+		s.abort()
+		return ctx.Err()
+	}
+}
+
+func main() {
+	start := time.Date(2022, 9, 3, 16, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	s := NewScheduler(clock)
+
+	// 6-default.go without sleeping:
+	// tick. every 100ms, BOOM! once after 550ms
+	done := make(chan string)
+	report := func(msg string) func(context.Context) {
+		return func(context.Context) {
+			done <- fmt.Sprintf("%4dms %s", clock.Now().Sub(start).Milliseconds(), msg)
+		}
+	}
+	tick, _ := s.Add(Every(100*time.Millisecond), report("tick."))
+	s.Add(Once(start.Add(550*time.Millisecond)), report("BOOM!"))
+	// move in steps of 50ms and wait for the runs due in each step
+	for step := 1; step <= 12; step++ {
+		// both loops have a timer until BOOM! is gone
+		timers := 2
+		if step > 11 {
+			timers = 1
+		}
+		clock.BlockUntil(timers)
+		clock.Advance(50 * time.Millisecond)
+		if step%2 == 0 || step == 11 {
+			fmt.Println(<-done)
+		}
+	}
+	s.Remove(tick)
+	s.Stop(context.Background())
+
+	// overlap: a job every second that blocks until released
+	for _, policy := range []Overlap{Skip, Queue} {
+		clock := newFakeClock(start)
+		s := NewScheduler(clock)
+		release := make(chan struct{})
+		id, _ := s.Add(Every(time.Second), func(context.Context) { <-release }, WithOverlap(policy))
+		// 1 run starts, 3 more come due while it blocks
+		for i := 0; i < 4; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Second)
+		}
+		clock.BlockUntil(1)
+		close(release)
+		s.Stop(context.Background())
+		ran, skipped := s.Stats(id)
+		// Skip: ran 1, skipped 3 / Queue: ran 4, skipped 0
+		fmt.Printf("%v: ran %d, skipped %d\n", policy, ran, skipped)
+		// a job can still be removed after Stop
+		s.Remove(id)
+	}
+
+	// Stop gives up at its deadline, even on a job that ignores its context:
+	// context deadline exceeded
+	s = NewScheduler(clock)
+	release := make(chan struct{})
+	s.Add(Once(clock.Now().Add(time.Second)), func(context.Context) { <-release })
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fmt.Println(s.Stop(ctx))
+	close(release)
+
+	// cron: every quarter hour during office hours on weekdays
+	sched, err := Cron("*/15 9-17 * * 1-5")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// 2022-09-03 is a Saturday, so the first runs are on Monday
+	t := start
+	for i := 0; i < 3; i++ {
+		t = sched.Next(t)
+		fmt.Println(t.Format("Mon 2006-01-02 15:04"))
+	}
+	if _, err := Cron("61 * * * *"); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,337 @@
+/*
+ 1. a topic-based broker: every subscriber gets its own buffered channel
+ 2. a full channel is handled by the policy of the subscriber
+    Block       the publisher waits (until the subscriber leaves)
+    DropNewest  the new message is dropped
+    DropOldest  the oldest buffered message makes room for it
+    Disconnect  the subscriber is dropped, its channel closed
+ 3. every drop is counted, per subscriber and for the whole broker
+ 4. non-blocking sends are `select` with a `default` case,
+    as in `6-default.go`
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy says what to do when a subscriber's buffer is full.
+type Policy int
+
+const (
+	Block Policy = iota
+	DropNewest
+	DropOldest
+	Disconnect
+)
+
+func (p Policy) String() string {
+	return [...]string{"Block", "DropNewest", "DropOldest", "Disconnect"}[p]
+}
+
+// Subscription receives the messages of one topic.
+type Subscription[T any] struct {
+	broker *Broker[T]
+	topic  string
+	policy Policy
+	ch     chan T
+	done   chan struct{} // closed first on unsubscribe, wakes up blocked publishers
+	once   sync.Once     // closes done
+
+	mu      sync.Mutex // serializes deliveries and closing ch
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// C returns the channel of messages.
+// It is closed after Unsubscribe, a disconnect or Broker.Close.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// Dropped returns the number of messages this subscriber missed.
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops the subscription and closes its channel.
+func (s *Subscription[T]) Unsubscribe() {
+	s.broker.remove(s)
+	s.close()
+}
+
+func (s *Subscription[T]) close() {
+	s.once.Do(func() { close(s.done) })
+	// a blocked publisher holds mu until it sees done
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+	s.mu.Unlock()
+}
+
+// deliver sends m according to the policy and reports whether it was delivered.
+func (s *Subscription[T]) deliver(m T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+
+	// the buffer has room: every policy just sends
+	select {
+	case s.ch <- m:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- m:
+			return true
+		case <-s.done:
+			return false
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- m:
+				return true
+			default:
+			}
+			// the subscriber may have read it first, hence the default
+			select {
+			case <-s.ch:
+				s.drop()
+			default:
+			}
+		}
+	case Disconnect:
+		s.drop()
+		s.broker.remove(s)
+		s.broker.disconnected.Add(1)
+		s.closed = true
+		s.once.Do(func() { close(s.done) })
+		close(s.ch)
+		return false
+	default: // DropNewest
+		s.drop()
+		return false
+	}
+}
+
+func (s *Subscription[T]) drop() {
+	s.dropped.Add(1)
+	s.broker.dropped.Add(1)
+}
+
+// Stats are the counters of a broker.
+type Stats struct {
+	Published    uint64 // calls to Publish
+	Delivered    uint64 // messages put into subscriber channels
+	Dropped      uint64 // messages lost by full subscribers
+	Disconnected uint64 // subscribers dropped by the Disconnect policy
+}
+
+// Broker passes messages of type T from publishers to subscribers.
+// It is safe to use concurrently.
+type Broker[T any] struct {
+	mu     sync.RWMutex
+	topics map[string]map[*Subscription[T]]bool
+	closed bool
+
+	published, delivered, dropped, disconnected atomic.Uint64
+}
+
+// ErrClosed is returned by Subscribe after Close.
+var ErrClosed = errors.New("pubsub: broker closed")
+
+// NewBroker returns an empty broker.
+func NewBroker[T any]() *Broker[T] {
+	return &Broker[T]{topics: make(map[string]map[*Subscription[T]]bool)}
+}
+
+// Subscribe returns a subscription to topic with a buffer of size
+// and the given slow-consumer policy.
+// DropOldest needs a buffer of at least 1 to make room in.
+func (b *Broker[T]) Subscribe(topic string, size int, policy Policy) (*Subscription[T], error) {
+	switch {
+	case policy < Block || policy > Disconnect:
+		return nil, fmt.Errorf("pubsub: unknown policy %d", int(policy))
+	case size < 0:
+		return nil, fmt.Errorf("pubsub: negative buffer size %d", size)
+	case size < 1 && policy == DropOldest:
+		return nil, errors.New("pubsub: DropOldest needs a buffer size of at least 1")
+	}
+	s := &Subscription[T]{
+		broker: b,
+		topic:  topic,
+		policy: policy,
+		ch:     make(chan T, size),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*Subscription[T]]bool)
+	}
+	b.topics[topic][s] = true
+	return s, nil
+}
+
+func (b *Broker[T]) remove(s *Subscription[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.topics[s.topic], s)
+	if len(b.topics[s.topic]) == 0 {
+		delete(b.topics, s.topic)
+	}
+}
+
+// Publish sends m to every subscriber of topic
+// and returns how many of them got it.
+// It only blocks on subscribers with the Block policy.
+func (b *Broker[T]) Publish(topic string, m T) int {
+	b.published.Add(1)
+
+	// deliver outside the broker lock,
+	// so a blocked publisher does not hold up (un)subscribing
+	b.mu.RLock()
+	subs := make([]*Subscription[T], 0, len(b.topics[topic]))
+	for s := range b.topics[topic] {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+
+	n := 0
+	for _, s := range subs {
+		if s.deliver(m) {
+			n++
+		}
+	}
+	b.delivered.Add(uint64(n))
+	return n
+}
+
+// Stats returns the counters of the broker.
+func (b *Broker[T]) Stats() Stats {
+	return Stats{
+		Published:    b.published.Load(),
+		Delivered:    b.delivered.Load(),
+		Dropped:      b.dropped.Load(),
+		Disconnected: b.disconnected.Load(),
+	}
+}
+
+// Close unsubscribes everyone; later Subscribe calls fail.
+func (b *Broker[T]) Close() {
+	b.mu.Lock()
+	b.closed = true
+	var subs []*Subscription[T]
+	for _, set := range b.topics {
+		for s := range set {
+			subs = append(subs, s)
+		}
+	}
+	b.topics = make(map[string]map[*Subscription[T]]bool)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// drain returns what is left in a channel that is closed or idle.
+func drain[T any](ch <-chan T) []T {
+	var got []T
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		default:
+			return got
+		}
+	}
+}
+
+func main() {
+	b := NewBroker[int]()
+
+	// three subscribers that do not read while 10 messages are published,
+	// and a fourth one that reads slowly
+	policies := []Policy{DropNewest, DropOldest, Disconnect}
+	subs := make(map[Policy]*Subscription[int])
+	for _, p := range policies {
+		subs[p], _ = b.Subscribe("numbers", 3, p)
+	}
+	blocking, _ := b.Subscribe("numbers", 3, Block)
+
+	// the blocking subscriber reads in a select loop, like 6-default.go
+	got := make(chan []int)
+	go func() {
+		var seen []int
+		tick := time.Tick(time.Millisecond)
+		for {
+			select {
+			case v, ok := <-blocking.C():
+				if !ok {
+					got <- seen
+					return
+				}
+				seen = append(seen, v)
+			case <-tick:
+				// slow consumer: the publisher waits meanwhile
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		b.Publish("numbers", i)
+	}
+	// nobody listens here: 0
+	fmt.Println(b.Publish("letters", 0))
+
+	// DropNewest: [0 1 2] dropped 7
+	// DropOldest: [7 8 9] dropped 7
+	// Disconnect: [0 1 2] dropped 1
+	for _, p := range policies {
+		fmt.Printf("%v: %v dropped %d\n", p, drain(subs[p].C()), subs[p].Dropped())
+	}
+
+	b.Close()
+	// Block: [0 1 2 3 4 5 6 7 8 9] dropped 0
+	fmt.Printf("%v: %v dropped %d\n", Block, <-got, blocking.Dropped())
+
+	// {Published:11 Delivered:26 Dropped:15 Disconnected:1}
+	fmt.Printf("%+v\n", b.Stats())
+
+	// a publisher blocked on a subscriber is released when it leaves
+	b = NewBroker[int]()
+	s, _ := b.Subscribe("numbers", 0, Block)
+	published := make(chan int)
+	go func() { published <- b.Publish("numbers", 42) }()
+	time.Sleep(10 * time.Millisecond)
+	s.Unsubscribe()
+	// 0
+	fmt.Println(<-published)
+
+	// DropOldest has nothing to drop without a buffer:
+	// pubsub: DropOldest needs a buffer size of at least 1
+	_, err := b.Subscribe("numbers", 0, DropOldest)
+	fmt.Println(err)
+	// pubsub: unknown policy 7
+	_, err = b.Subscribe("numbers", 1, Policy(7))
+	fmt.Println(err)
+}
@@ -0,0 +1,516 @@
+/*
+ 1. the ticker of `6-default.go` lets one thing through per tick;
+    a rate limiter does the same, for callers that come at any time
+ 2. token bucket: tokens drip in at `rate` up to `burst`,
+    every call takes one, so short bursts are fine
+ 3. leaky bucket: calls leave at a steady `rate`,
+    at most `capacity` of them wait in the bucket
+ 4. both answer one question, "how long until this call may go?",
+    on which `Allow`, `Wait(ctx)` and `Reserve` are built
+ 5. `KeyedLimiter` keeps one limiter per key (client, user, ...)
+    and evicts idle ones; `Middleware` puts it in front of a handler
+*/
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of `time.Timer` the limiters need.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d and fires the timers
+// that are due, earliest (then oldest) first,
+// with the clock at the time of each timer while it fires.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	// the timers are kept in order of creation, so a stable sort breaks ties
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.when
+		t.c <- t.when
+	}
+	c.now = end
+}
+
+// BlockUntil waits until n timers are waiting.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// algorithm is what differs between the two buckets.
+// Both are called with the limiter locked.
+type algorithm interface {
+	// reserve takes a slot and returns how long the caller must wait for it,
+	// or ok=false (taking nothing) if that is longer than maxWait.
+	reserve(now time.Time, maxWait time.Duration) (delay time.Duration, ok bool)
+	// unreserve gives back a slot that will not be used.
+	unreserve(now time.Time)
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate per second.
+// tokens goes negative for reservations made in advance.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.refill(now)
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if delay > maxWait {
+		b.tokens++
+		return 0, false
+	}
+	return delay, true
+}
+
+func (b *tokenBucket) unreserve(now time.Time) {
+	b.refill(now)
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// leakyBucket lets one call leave every interval.
+// next is when the next call may leave; calls waiting for a later
+// slot are the content of the bucket.
+type leakyBucket struct {
+	interval time.Duration
+	capacity int
+	next     time.Time
+}
+
+func (b *leakyBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	// the bucket is full, or the caller does not want to wait that long
+	if delay > time.Duration(b.capacity)*b.interval || delay > maxWait {
+		return 0, false
+	}
+	b.next = b.next.Add(b.interval)
+	return delay, true
+}
+
+func (b *leakyBucket) unreserve(now time.Time) {
+	if b.next.Sub(now) >= b.interval {
+		b.next = b.next.Add(-b.interval)
+	}
+}
+
+// Limiter controls how often something may happen.
+// It is safe to use concurrently.
+type Limiter struct {
+	mu    sync.Mutex
+	clock Clock
+	alg   algorithm
+}
+
+// NewTokenBucket returns a limiter allowing rate calls per second
+// on average and bursts of up to burst calls.
+// A nil clock means the real time. It panics if rate is not positive.
+func NewTokenBucket(rate float64, burst int, clock Clock) *Limiter {
+	checkRate("NewTokenBucket", rate)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Limiter{clock: clock, alg: &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}}
+}
+
+// NewLeakyBucket returns a limiter letting calls through
+// evenly spaced at rate per second, with up to capacity calls waiting.
+// A nil clock means the real time. It panics if rate is not positive.
+func NewLeakyBucket(rate float64, capacity int, clock Clock) *Limiter {
+	checkRate("NewLeakyBucket", rate)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Limiter{clock: clock, alg: &leakyBucket{
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+	}}
+}
+
+// checkRate panics unless rate is a positive number:
+// the waits are computed as 1/rate, which is +Inf for 0
+// and does not fit in a time.Duration.
+func checkRate(name string, rate float64) {
+	if rate <= 0 || math.IsNaN(rate) {
+		panic(fmt.Sprintf("%s: rate must be positive, got %v", name, rate))
+	}
+}
+
+// Allow reports whether a call may happen now.
+func (l *Limiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.alg.reserve(l.clock.Now(), 0)
+	return ok
+}
+
+// Reservation is a slot taken in advance.
+type Reservation struct {
+	limiter *Limiter
+	ok      bool
+	at      time.Time // when the slot starts
+}
+
+// Reserve takes the next slot; the caller should wait for Delay before acting,
+// or Cancel if it does not act.
+func (l *Limiter) Reserve() *Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	delay, ok := l.alg.reserve(now, math.MaxInt64)
+	return &Reservation{limiter: l, ok: ok, at: now.Add(delay)}
+}
+
+// OK reports whether a slot was taken (a full leaky bucket takes none).
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long to wait before the reserved slot.
+func (r *Reservation) Delay() time.Duration {
+	if d := r.at.Sub(r.limiter.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Cancel gives the slot back.
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.ok = false
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	r.limiter.alg.unreserve(r.limiter.clock.Now())
+}
+
+// ErrWouldExceedDeadline is returned by Wait when the slot comes too late.
+var ErrWouldExceedDeadline = errors.New("ratelimit: wait would exceed context deadline")
+
+// Wait blocks until a call may happen or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	maxWait := time.Duration(math.MaxInt64)
+	l.mu.Lock()
+	now := l.clock.Now()
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+	delay, ok := l.alg.reserve(now, maxWait)
+	l.mu.Unlock()
+	if !ok {
+		return ErrWouldExceedDeadline
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	timer := l.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		// give the slot back to the next caller
+		l.mu.Lock()
+		l.alg.unreserve(l.clock.Now())
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// KeyedLimiter keeps one limiter per key.
+// Limiters idle for longer than idle are evicted,
+// and so is the least recently used one beyond max keys.
+type KeyedLimiter struct {
+	mu    sync.Mutex
+	clock Clock
+	new   func() *Limiter
+	idle  time.Duration
+	max   int
+	lru   *list.List // of *keyed, most recently used first
+	keys  map[string]*list.Element
+}
+
+type keyed struct {
+	key  string
+	lim  *Limiter
+	used time.Time
+}
+
+// NewKeyedLimiter returns a KeyedLimiter making limiters with newLimiter.
+// It panics unless idle and max are positive: a limiter that is evicted
+// right away starts every call with a fresh bucket, which limits nothing.
+func NewKeyedLimiter(newLimiter func() *Limiter, idle time.Duration, max int, clock Clock) *KeyedLimiter {
+	if idle <= 0 {
+		panic(fmt.Sprintf("NewKeyedLimiter: idle must be positive, got %v", idle))
+	}
+	if max < 1 {
+		panic(fmt.Sprintf("NewKeyedLimiter: max must be positive, got %d", max))
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &KeyedLimiter{
+		clock: clock,
+		new:   newLimiter,
+		idle:  idle,
+		max:   max,
+		lru:   list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the limiter of key, making it if needed.
+func (k *KeyedLimiter) Get(key string) *Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.clock.Now()
+	k.evict(now)
+	if e, ok := k.keys[key]; ok {
+		e.Value.(*keyed).used = now
+		k.lru.MoveToFront(e)
+		return e.Value.(*keyed).lim
+	}
+	kd := &keyed{key: key, lim: k.new(), used: now}
+	k.keys[key] = k.lru.PushFront(kd)
+	if k.lru.Len() > k.max {
+		k.remove(k.lru.Back())
+	}
+	return kd.lim
+}
+
+// evict removes the limiters idle for too long, from the back of the list.
+func (k *KeyedLimiter) evict(now time.Time) {
+	for e := k.lru.Back(); e != nil && now.Sub(e.Value.(*keyed).used) > k.idle; e = k.lru.Back() {
+		k.remove(e)
+	}
+}
+
+func (k *KeyedLimiter) remove(e *list.Element) {
+	k.lru.Remove(e)
+	delete(k.keys, e.Value.(*keyed).key)
+}
+
+// Allow reports whether a call for key may happen now.
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+
+// Len returns the number of limiters kept, after evicting idle ones.
+func (k *KeyedLimiter) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.evict(k.clock.Now())
+	return k.lru.Len()
+}
+
+// Middleware rejects requests over the limit of their key
+// with 429 Too Many Requests and a Retry-After header.
+func Middleware(k *KeyedLimiter, key func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := k.Get(key(r)).Reserve()
+		if d := res.Delay(); !res.OK() || d > 0 {
+			res.Cancel()
+			secs := int(math.Ceil(d.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func main() {
+	start := time.Date(2022, 9, 3, 16, 0, 0, 0, time.UTC)
+
+	// one call every 10ms for 10s, against limits of 10 calls per second
+	for _, name := range []string{"token bucket", "leaky bucket"} {
+		clock := newFakeClock(start)
+		lim := NewTokenBucket(10, 5, clock)
+		if name == "leaky bucket" {
+			lim = NewLeakyBucket(10, 5, clock)
+		}
+		allowed := 0
+		var first []int
+		for i := 0; i < 1000; i++ {
+			if lim.Allow() {
+				allowed++
+				if len(first) < 7 {
+					first = append(first, i*10)
+				}
+			}
+			clock.Advance(10 * time.Millisecond)
+		}
+		// token bucket: 104 allowed (5 + 9.99s * 10/s), a burst first: [0 10 20 30 40 100 200] ms
+		// leaky bucket: 100 allowed, evenly spaced: [0 100 200 300 400 500 600] ms
+		fmt.Printf("%s: %d allowed, first at %v ms\n", name, allowed, first)
+	}
+
+	// Wait blocks until the next slot, cancelling gives it back
+	clock := newFakeClock(start)
+	lim := NewTokenBucket(1, 1, clock)
+	lim.Allow()
+	done := make(chan error)
+	go func() { done <- lim.Wait(context.Background()) }()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	// waited 1s: <nil>
+	fmt.Println("waited 1s:", <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- lim.Wait(ctx) }()
+	clock.BlockUntil(1)
+	cancel()
+	// cancelled: context canceled
+	// the slot it gave back makes the next one come a second earlier
+	fmt.Println("cancelled:", <-done)
+	clock.Advance(time.Second)
+	fmt.Println("allowed after cancel:", lim.Allow())
+
+	// Reserve: the caller decides whether to wait
+	r := lim.Reserve()
+	fmt.Println("reserved, delay:", r.Delay())
+
+	// per-client limits over HTTP: 2 requests per client, then 429
+	keyed := NewKeyedLimiter(func() *Limiter { return NewTokenBucket(1, 2, clock) }, time.Minute, 100, clock)
+	h := Middleware(keyed, func(r *http.Request) string { return r.RemoteAddr },
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") }))
+	codes := make(map[string][]int)
+	for i := 0; i < 3; i++ {
+		for _, client := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = client
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			codes[client] = append(codes[client], rec.Code)
+		}
+	}
+	clients := make([]string, 0, len(codes))
+	for c := range codes {
+		clients = append(clients, c)
+	}
+	sort.Strings(clients)
+	for _, c := range clients {
+		// [200 200 429]
+		fmt.Println(c, codes[c])
+	}
+
+	// idle clients are forgotten: 2 keys, then 0
+	fmt.Print(keyed.Len(), " keys, ")
+	clock.Advance(2 * time.Minute)
+	fmt.Println(keyed.Len(), "keys after 2 minutes")
+}
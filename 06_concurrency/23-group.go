@@ -0,0 +1,215 @@
+/*
+ 1. `1-goroutines.go` starts `go say("world")` and hopes it is done
+    by the time `main` returns; a `Group` waits for it instead
+ 2. the first error of a child cancels the context of all the others
+ 3. a panic in a child is recovered with its stack,
+    cancels the others too, and is raised again in `Wait`,
+    even if another child returned an error before
+ 4. `SetLimit(n)` lets at most n children run at once;
+    `Go` blocks until one of them is done
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PanicError is a panic recovered in a child goroutine.
+type PanicError struct {
+	Value any
+	Stack []byte // of the goroutine that panicked
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic in group goroutine: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Group runs goroutines as children of the caller.
+// The zero Group has no context and no limit.
+type Group struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	errOnce   sync.Once
+	err       error
+	panicOnce sync.Once
+	panic     *PanicError
+}
+
+// WithContext returns a Group and a context
+// that is cancelled on the first error or panic of a child, or after Wait.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// SetLimit lets at most n children run at once (n < 0 means no limit).
+// It must not be called while children are running.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs f in a new goroutine, blocking first if the limit is reached.
+func (g *Group) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	go g.run(f)
+}
+
+// TryGo runs f only if the limit is not reached, and reports whether it did.
+func (g *Group) TryGo(f func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.wg.Add(1)
+	go g.run(f)
+	return true
+}
+
+func (g *Group) run(f func() error) {
+	defer func() {
+		if v := recover(); v != nil {
+			g.panicked(&PanicError{Value: v, Stack: debug.Stack()})
+		}
+		if g.sem != nil {
+			<-g.sem
+		}
+		g.wg.Done()
+	}()
+	if err := f(); err != nil {
+		g.fail(err)
+	}
+}
+
+// fail keeps the first error and cancels the siblings.
+func (g *Group) fail(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		if g.cancel != nil {
+			g.cancel()
+		}
+	})
+}
+
+// panicked keeps the first panic, apart from the errors,
+// so an earlier error does not swallow it, and cancels the siblings.
+func (g *Group) panicked(p *PanicError) {
+	g.panicOnce.Do(func() {
+		g.panic = p
+		if g.cancel != nil {
+			g.cancel()
+		}
+	})
+}
+
+// Wait waits for all children and returns the first error.
+// If a child panicked, Wait panics with a *PanicError
+// holding the value and the stack of the first panic,
+// whether or not another child failed first.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	if g.panic != nil {
+		panic(g.panic)
+	}
+	return g.err
+}
+
+func say(s string) {
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Println(s)
+	}
+}
+
+func main() {
+	// 1-goroutines.go, but "world" is always printed five times
+	var g Group
+	g.Go(func() error {
+		say("world")
+		return nil
+	})
+	say("hello")
+	g.Wait()
+
+	// the first error cancels the siblings
+	g2, ctx := WithContext(context.Background())
+	g2.Go(func() error {
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("first child failed")
+	})
+	g2.Go(func() error {
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	// first child failed
+	fmt.Println(g2.Wait())
+
+	// a panic comes back in the waiter, with the child's stack,
+	// even after the error of a sibling
+	func() {
+		defer func() {
+			p := recover().(*PanicError)
+			fmt.Println("recovered:", p.Value)
+			// the stack names the function that panicked
+			fmt.Println("stack has main.main.func:", strings.Contains(string(p.Stack), "main.main.func"))
+		}()
+		g3, _ := WithContext(context.Background())
+		g3.Go(func() error {
+			return errors.New("failed before the panic")
+		})
+		g3.Go(func() error {
+			time.Sleep(10 * time.Millisecond)
+			var m map[string]int
+			m["somekey"]++ // assignment to entry in nil map
+			return nil
+		})
+		g3.Wait()
+	}()
+
+	// at most 3 of 10 children at once
+	var g4 Group
+	g4.SetLimit(3)
+	var running, most atomic.Int32
+	for i := 0; i < 10; i++ {
+		g4.Go(func() error {
+			n := running.Add(1)
+			for {
+				m := most.Load()
+				if n <= m || most.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+	}
+	g4.Wait()
+	// at most 3 running
+	fmt.Println("at most", most.Load(), "running")
+}
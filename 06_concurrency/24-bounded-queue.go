@@ -0,0 +1,351 @@
+/*
+ 1. a buffered channel blocks (or deadlocks) when it is full,
+    and cannot be peeked at, resized, or ordered
+ 2. `Queue[T]` is a bounded queue with the same blocking behaviour,
+    but `Put`/`Get` give up when their context is done
+    and `TryPut`/`TryGet` never block
+ 3. after `Close`, `Put` fails but `Get` still drains what is left
+ 4. `NewPriorityQueue` hands out the smallest item first
+ 5. blocked callers wait on a channel that is closed on every change,
+    so they can `select` on it together with `ctx.Done()`
+ 6. `go run -race 24-bounded-queue.go` runs the stress test under the race detector
+*/
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Put after Close, and by Get once a closed queue is empty.
+var ErrClosed = errors.New("queue: closed")
+
+// store holds the items, in FIFO or priority order.
+type store[T any] interface {
+	push(v T)
+	pop() T
+	peek() T
+	len() int
+}
+
+// fifo is a slice used as a queue.
+type fifo[T any] struct {
+	items []T
+	head  int
+}
+
+func (f *fifo[T]) push(v T) { f.items = append(f.items, v) }
+func (f *fifo[T]) peek() T  { return f.items[f.head] }
+func (f *fifo[T]) len() int { return len(f.items) - f.head }
+
+func (f *fifo[T]) pop() T {
+	v := f.items[f.head]
+	var zero T
+	f.items[f.head] = zero // let the garbage collector have it
+	f.head++
+	// move the items to the front once half of the slice is unused
+	if f.head > len(f.items)/2 {
+		n := copy(f.items, f.items[f.head:])
+		f.items = f.items[:n]
+		f.head = 0
+	}
+	return v
+}
+
+// prio is a heap; seq keeps equal items in FIFO order.
+type prio[T any] struct {
+	items []prioItem[T]
+	less  func(a, b T) bool
+	seq   uint64
+}
+
+type prioItem[T any] struct {
+	v   T
+	seq uint64
+}
+
+func (p *prio[T]) Len() int { return len(p.items) }
+func (p *prio[T]) Less(i, j int) bool {
+	a, b := p.items[i], p.items[j]
+	if p.less(a.v, b.v) {
+		return true
+	}
+	if p.less(b.v, a.v) {
+		return false
+	}
+	return a.seq < b.seq
+}
+func (p *prio[T]) Swap(i, j int) { p.items[i], p.items[j] = p.items[j], p.items[i] }
+func (p *prio[T]) Push(x any)    { p.items = append(p.items, x.(prioItem[T])) }
+func (p *prio[T]) Pop() any {
+	it := p.items[len(p.items)-1]
+	p.items = p.items[:len(p.items)-1]
+	return it
+}
+
+func (p *prio[T]) push(v T) {
+	p.seq++
+	heap.Push(p, prioItem[T]{v, p.seq})
+}
+func (p *prio[T]) pop() T   { return heap.Pop(p).(prioItem[T]).v }
+func (p *prio[T]) peek() T  { return p.items[0].v }
+func (p *prio[T]) len() int { return len(p.items) }
+
+// Queue is a bounded queue, safe to use concurrently.
+type Queue[T any] struct {
+	mu      sync.Mutex
+	items   store[T]
+	cap     int
+	closed  bool
+	changed chan struct{} // closed and replaced whenever the queue changes
+}
+
+// NewQueue returns a FIFO queue holding up to capacity items.
+func NewQueue[T any](capacity int) *Queue[T] {
+	return &Queue[T]{items: &fifo[T]{}, cap: capacity, changed: make(chan struct{})}
+}
+
+// NewPriorityQueue returns a queue holding up to capacity items
+// that hands out the smallest item (according to less) first.
+func NewPriorityQueue[T any](capacity int, less func(a, b T) bool) *Queue[T] {
+	return &Queue[T]{items: &prio[T]{less: less}, cap: capacity, changed: make(chan struct{})}
+}
+
+// broadcast wakes up every waiting caller; q.mu must be held.
+func (q *Queue[T]) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// wait blocks until ready() is true, the queue is closed, or ctx is done.
+// q.mu is held on entry and on return.
+func (q *Queue[T]) wait(ctx context.Context, ready func() bool) error {
+	for !ready() && !q.closed {
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			q.mu.Lock()
+			return ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	return nil
+}
+
+// Put adds v, waiting for room if the queue is full.
+func (q *Queue[T]) Put(ctx context.Context, v T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.wait(ctx, func() bool { return q.items.len() < q.cap }); err != nil {
+		return err
+	}
+	if q.closed {
+		return ErrClosed
+	}
+	q.items.push(v)
+	q.broadcast()
+	return nil
+}
+
+// TryPut adds v if there is room and reports whether it did.
+func (q *Queue[T]) TryPut(v T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.items.len() >= q.cap {
+		return false
+	}
+	q.items.push(v)
+	q.broadcast()
+	return true
+}
+
+// Get removes and returns the next item, waiting for one if the queue is empty.
+// Once the queue is closed and empty it returns ErrClosed.
+func (q *Queue[T]) Get(ctx context.Context) (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var zero T
+	if err := q.wait(ctx, func() bool { return q.items.len() > 0 }); err != nil {
+		return zero, err
+	}
+	// closed, but the items left are still handed out
+	if q.items.len() == 0 {
+		return zero, ErrClosed
+	}
+	v := q.items.pop()
+	q.broadcast()
+	return v, nil
+}
+
+// TryGet removes and returns the next item if there is one.
+func (q *Queue[T]) TryGet() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items.len() == 0 {
+		var zero T
+		return zero, false
+	}
+	v := q.items.pop()
+	q.broadcast()
+	return v, true
+}
+
+// Peek returns the next item without removing it.
+func (q *Queue[T]) Peek() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items.len() == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.items.peek(), true
+}
+
+// Len returns the number of items in the queue.
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items.len()
+}
+
+// Cap returns the capacity of the queue.
+func (q *Queue[T]) Cap() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cap
+}
+
+// Resize changes the capacity. Items over a smaller capacity are kept,
+// and Put waits until the queue has drained below it.
+func (q *Queue[T]) Resize(capacity int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cap = capacity
+	q.broadcast()
+}
+
+// Close stops further Puts; blocked Puts return ErrClosed,
+// and Get keeps returning the items left until the queue is empty.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.broadcast()
+	}
+}
+
+// stress runs producers and consumers on q and checks
+// that every item comes out exactly once.
+func stress(q *Queue[int], producers, consumers, perProducer int) error {
+	ctx := context.Background()
+	var pwg, cwg sync.WaitGroup
+	seen := make([]int, producers*perProducer)
+	var mu sync.Mutex
+
+	for p := 0; p < producers; p++ {
+		pwg.Add(1)
+		go func(p int) {
+			defer pwg.Done()
+			for i := 0; i < perProducer; i++ {
+				v := p*perProducer + i
+				// mix blocking and non-blocking puts
+				if i%2 == 0 && q.TryPut(v) {
+					continue
+				}
+				if err := q.Put(ctx, v); err != nil {
+					panic(err)
+				}
+			}
+		}(p)
+	}
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for {
+				v, err := q.Get(ctx)
+				if err == ErrClosed {
+					return
+				}
+				mu.Lock()
+				seen[v]++
+				mu.Unlock()
+			}
+		}()
+	}
+	pwg.Wait()
+	q.Close()
+	cwg.Wait()
+
+	for v, n := range seen {
+		if n != 1 {
+			return fmt.Errorf("item %d seen %d times", v, n)
+		}
+	}
+	return nil
+}
+
+func main() {
+	// 3-buffered-channels.go, but a third send gives up instead of deadlocking
+	q := NewQueue[int](2)
+	q.Put(context.Background(), 1)
+	q.Put(context.Background(), 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// context deadline exceeded
+	fmt.Println(q.Put(ctx, 3))
+	// false
+	fmt.Println(q.TryPut(3))
+
+	// peek, then resize to make room for the third one: 1 true 3
+	v, _ := q.Peek()
+	q.Resize(3)
+	fmt.Println(v, q.TryPut(3), q.Len())
+
+	// close: the items left can still be read, then ErrClosed
+	q.Close()
+	for {
+		v, err := q.Get(context.Background())
+		if err != nil {
+			// 1 2 3 queue: closed
+			fmt.Println(err)
+			break
+		}
+		fmt.Print(v, " ")
+	}
+
+	// priority: the smallest first, equal ones in FIFO order
+	type job struct {
+		prio int
+		name string
+	}
+	pq := NewPriorityQueue(10, func(a, b job) bool { return a.prio < b.prio })
+	for _, j := range []job{{2, "b"}, {1, "a"}, {3, "c"}, {1, "a2"}} {
+		pq.TryPut(j)
+	}
+	for j, ok := pq.TryGet(); ok; j, ok = pq.TryGet() {
+		// a a2 b c
+		fmt.Print(j.name, " ")
+	}
+	fmt.Println()
+
+	// stress: 8 producers, 8 consumers, a queue much smaller than the load
+	start := time.Now()
+	if err := stress(NewQueue[int](4), 8, 8, 5000); err != nil {
+		fmt.Println("fifo:", err)
+		return
+	}
+	less := func(a, b int) bool { return a < b }
+	if err := stress(NewPriorityQueue(4, less), 8, 8, 5000); err != nil {
+		fmt.Println("priority:", err)
+		return
+	}
+	fmt.Println("stress: ok in", time.Since(start).Round(time.Millisecond))
+}
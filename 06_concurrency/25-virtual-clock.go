@@ -0,0 +1,381 @@
+/*
+ 1. `say`, `sum`, the tick/boom loop and the mutex counter all sleep,
+    so checking their output takes real time, and the result depends on timing
+ 2. a `Clock` is everything they use from the time package:
+    `Now`, `Sleep`, `After`, `Tick`, `NewTimer`
+ 3. `realClock` forwards to the time package;
+    `fakeClock` only moves when `Advance` is called,
+    and fires everything that is due in order, earliest (then oldest) first
+ 4. `BlockUntil(n)` waits until n sleepers/timers/tickers are waiting,
+    i.e. until the goroutines under test are all parked on the clock
+ 5. `1-goroutines.go`, `2-channels.go`, `6-default.go` and `8-mutex-counter.go`
+    take a `Clock` and run on `realClock`; every file is a program of its own,
+    so the functions are copied below and checked in `main`
+    against their exact output, without sleeping at all
+ 6. a failed check makes the program exit with status 1
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock is the part of the time package the examples use.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	Tick(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of `time.Timer` the examples use.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Tick(d time.Duration) <-chan time.Time  { return time.Tick(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+// the examples run on the real clock unchanged, e.g. say(realClock{}, os.Stdout, "hello")
+var _ Clock = realClock{}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// fakeClock is a clock that tests move by hand.
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled when a waiter is added
+	now     time.Time
+	seq     uint64
+	waiters []*waiter
+}
+
+// waiter is a sleeper, a timer or (with period > 0) a ticker.
+type waiter struct {
+	clock  *fakeClock
+	when   time.Time
+	seq    uint64 // order of creation, to break ties
+	period time.Duration
+	c      chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// add registers a waiter firing after d; c.mu must be held.
+func (c *fakeClock) add(w *waiter, d time.Duration) {
+	c.seq++
+	w.when, w.seq = c.now.Add(d), c.seq
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+func (c *fakeClock) newWaiter(d, period time.Duration) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{clock: c, period: period, c: make(chan time.Time, 1)}
+	c.add(w, d)
+	return w
+}
+
+func (c *fakeClock) Sleep(d time.Duration) { <-c.After(d) }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time { return c.newWaiter(d, 0).c }
+
+func (c *fakeClock) Tick(d time.Duration) <-chan time.Time { return c.newWaiter(d, d).c }
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer { return c.newWaiter(d, 0) }
+
+func (w *waiter) C() <-chan time.Time { return w.c }
+
+// remove unregisters w and reports whether it was waiting; c.mu must be held.
+func (c *fakeClock) remove(w *waiter) bool {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *waiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *waiter) Reset(d time.Duration) bool {
+	c := w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.remove(w)
+	c.add(w, d)
+	return active
+}
+
+// Advance moves the clock forward by d, firing everything that is due
+// in time order. A ticker that is not read drops ticks, like `time.Ticker`.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool {
+			a, b := c.waiters[i], c.waiters[j]
+			if !a.when.Equal(b.when) {
+				return a.when.Before(b.when)
+			}
+			return a.seq < b.seq
+		})
+		if len(c.waiters) == 0 || c.waiters[0].when.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.when
+		select {
+		case w.c <- w.when:
+		default:
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil waits until at least n sleepers, timers or tickers are waiting.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// say of 1-goroutines.go
+func say(clock Clock, w io.Writer, s string) {
+	for i := 0; i < 5; i++ {
+		clock.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(w, s)
+	}
+}
+
+// sum of 2-channels.go
+func sum(clock Clock, s []int, c chan int) {
+	sum := 0
+	for _, v := range s {
+		clock.Sleep(100 * time.Millisecond)
+		sum += v
+	}
+	c <- sum
+}
+
+// tickBoom of 6-default.go
+func tickBoom(clock Clock, w io.Writer, every, after time.Duration) {
+	tick := clock.Tick(every)
+	boom := clock.After(after)
+	for {
+		select {
+		case <-tick:
+			fmt.Fprintln(w, "tick.")
+		case <-boom:
+			fmt.Fprintln(w, "BOOM!")
+			return
+		default:
+			fmt.Fprintln(w, "    .")
+			clock.Sleep(every / 2)
+		}
+	}
+}
+
+// SafeCounter of 8-mutex-counter.go.
+type SafeCounter struct {
+	mu sync.Mutex
+	v  map[string]int
+}
+
+func (c *SafeCounter) Inc(key string) {
+	c.mu.Lock()
+	c.v[key]++
+	c.mu.Unlock()
+}
+
+func (c *SafeCounter) Value(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v[key]
+}
+
+// counter is 8-mutex-counter.go; its `clock.Sleep(20ms)` never guaranteed
+// that the 1000 goroutines were done, so no clock can make it
+// deterministic: it waits for them instead.
+func counter() int {
+	c := SafeCounter{v: make(map[string]int)}
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc("somekey")
+		}()
+	}
+	wg.Wait()
+	return c.Value("somekey")
+}
+
+// stamped prefixes every line with the time of the clock,
+// so the output says when something happened, not just in which order.
+type stamped struct {
+	mu    sync.Mutex
+	clock Clock
+	start time.Time
+	lines []string
+}
+
+func (s *stamped) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at := s.clock.Now().Sub(s.start).Milliseconds()
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		s.lines = append(s.lines, fmt.Sprintf("%dms %s", at, line))
+	}
+	return len(p), nil
+}
+
+// sorted returns the lines sorted, for goroutines printing at the same instant.
+func (s *stamped) sorted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := append([]string(nil), s.lines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		var a, b int
+		fmt.Sscanf(lines[i], "%dms", &a)
+		fmt.Sscanf(lines[j], "%dms", &b)
+		if a != b {
+			return a < b
+		}
+		return lines[i] < lines[j]
+	})
+	return lines
+}
+
+// failed is set by check, for the exit status.
+var failed bool
+
+func check(name string, got, want []string) {
+	if strings.Join(got, "\n") == strings.Join(want, "\n") {
+		fmt.Println("ok  ", name)
+		return
+	}
+	failed = true
+	fmt.Printf("FAIL %s\ngot:\n%s\nwant:\n%s\n", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+}
+
+func main() {
+	start := time.Date(2022, 9, 3, 16, 0, 0, 0, time.UTC)
+	realStart := time.Now()
+
+	// say: both goroutines print at 100ms, 200ms, ... 500ms
+	{
+		clock := newFakeClock(start)
+		out := &stamped{clock: clock, start: start}
+		done := make(chan bool)
+		go func() { say(clock, out, "world"); done <- true }()
+		go func() { say(clock, out, "hello"); done <- true }()
+		for i := 0; i < 5; i++ {
+			clock.BlockUntil(2)
+			clock.Advance(100 * time.Millisecond)
+		}
+		<-done
+		<-done
+		var want []string
+		for i := 1; i <= 5; i++ {
+			want = append(want, fmt.Sprintf("%dms hello", i*100), fmt.Sprintf("%dms world", i*100))
+		}
+		check("say", out.sorted(), want)
+	}
+
+	// sum: both halves are done after 300ms, x+y is always 12
+	{
+		clock := newFakeClock(start)
+		s := []int{7, 2, 8, -9, 4, 0}
+		c := make(chan int)
+		go sum(clock, s[:len(s)/2], c)
+		go sum(clock, s[len(s)/2:], c)
+		for i := 0; i < 3; i++ {
+			clock.BlockUntil(2)
+			clock.Advance(100 * time.Millisecond)
+		}
+		x, y := <-c, <-c
+		if x > y {
+			x, y = y, x
+		}
+		got := []string{fmt.Sprint(x, y, x+y), fmt.Sprint(clock.Now().Sub(start))}
+		check("sum", got, []string{"-5 17 12", "300ms"})
+	}
+
+	// tick/boom: the exact sequence, with BOOM! at 550ms so it never ties with a tick
+	{
+		clock := newFakeClock(start)
+		out := &stamped{clock: clock, start: start}
+		done := make(chan bool)
+		go func() { tickBoom(clock, out, 100*time.Millisecond, 550*time.Millisecond); done <- true }()
+		// ticker, boom and the sleeping loop
+		for i := 0; i < 11; i++ {
+			clock.BlockUntil(3)
+			clock.Advance(50 * time.Millisecond)
+		}
+		<-done
+		// at 550ms the boom timer fires before the loop wakes up from its sleep
+		want := []string{"0ms     ."}
+		for t := 100; t <= 500; t += 100 {
+			want = append(want,
+				fmt.Sprintf("%dms     .", t-50),
+				fmt.Sprintf("%dms tick.", t),
+				fmt.Sprintf("%dms     .", t))
+		}
+		want = append(want, "550ms BOOM!")
+		check("tick/boom", out.lines, want)
+	}
+
+	// the counter does not need a clock at all once it waits properly
+	check("counter", []string{fmt.Sprint(counter())}, []string{"1000"})
+
+	// 1.35s of example time, in much less real time
+	fmt.Println("real time under 100ms:", time.Since(realStart) < 100*time.Millisecond)
+
+	if failed {
+		os.Exit(1)
+	}
+}
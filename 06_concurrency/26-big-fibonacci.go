@@ -0,0 +1,163 @@
+/*
+ 1. the `fibonacci` of `03_advanced_types/func.go`, `4-range-and-close.go`
+    and `5-select.go` all use `int`, which overflows after F(92)
+ 2. here the numbers are `*big.Int`, as a closure (`Iterator`)
+    or as a channel that is closed after n values (`Chan`)
+ 3. every value handed out is a new `big.Int`,
+    so the caller may keep or change it
+ 4. `Nth` computes F(n) directly in O(log n) steps by fast doubling:
+    F(2k)   = F(k) * (2*F(k+1) - F(k))
+    F(2k+1) = F(k)^2 + F(k+1)^2
+ 5. `main` checks against known values and Cassini's identity,
+    and `testing.Benchmark` compares `Nth` with stepping through the sequence
+*/
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// Iterator returns a function that returns F(0), F(1), F(2), ... on each call.
+func Iterator() func() *big.Int {
+	a, b := big.NewInt(0), big.NewInt(1)
+	return func() *big.Int {
+		f := new(big.Int).Set(a)
+		// a, b = b, a+b
+		a.Add(a, b)
+		a, b = b, a
+		return f
+	}
+}
+
+// Chan sends F(0) ... F(n-1) on the returned channel, then closes it.
+func Chan(n int) <-chan *big.Int {
+	c := make(chan *big.Int)
+	go func() {
+		next := Iterator()
+		for i := 0; i < n; i++ {
+			c <- next()
+		}
+		close(c)
+	}()
+	return c
+}
+
+// Nth returns F(n) by fast doubling.
+func Nth(n uint) *big.Int {
+	// a = F(k), b = F(k+1), starting at k = 0
+	a, b := big.NewInt(0), big.NewInt(1)
+	t := new(big.Int)
+	for bit := bits.Len(n) - 1; bit >= 0; bit-- {
+		// k -> 2k
+		t.Lsh(b, 1).Sub(t, a).Mul(t, a) // F(2k)
+		b.Mul(b, b)
+		a.Mul(a, a)
+		b.Add(b, a) // F(2k+1)
+		a, t = t, a
+		// 2k -> 2k+1
+		if n>>bit&1 == 1 {
+			a.Add(a, b)
+			a, b = b, a
+		}
+	}
+	return a
+}
+
+// linear returns F(n) by stepping through the sequence.
+func linear(n uint) *big.Int {
+	next := Iterator()
+	for i := uint(0); i < n; i++ {
+		next()
+	}
+	return next()
+}
+
+// intFibonacci is the closure of `03_advanced_types/func.go`.
+func intFibonacci() func() int {
+	n1, n2 := 0, 1
+	return func() int {
+		temp := n1
+		n1, n2 = n2, n1+n2
+		return temp
+	}
+}
+
+func main() {
+	// 4-range-and-close.go: 0 1 1 2 3 5 8 13 21 34
+	for f := range Chan(10) {
+		fmt.Print(f, " ")
+	}
+	fmt.Println()
+
+	// F(93) no longer fits into an int (on a 64-bit platform)
+	ints, bigs := intFibonacci(), Iterator()
+	for i := 0; i <= 93; i++ {
+		n, f := ints(), bigs()
+		if i >= 92 {
+			// F(92) 7540113804746346429 7540113804746346429
+			// F(93) -6246583658587674878 12200160415121876738
+			fmt.Printf("F(%d) %d %v\n", i, n, f)
+		}
+	}
+
+	// known values
+	known := map[uint]string{
+		0:    "0",
+		1:    "1",
+		2:    "1",
+		92:   "7540113804746346429",
+		93:   "12200160415121876738",
+		100:  "354224848179261915075",
+		300:  "222232244629420445529739893461909967206666939096499764990979600",
+		1000: "43466557686937456435688527675040625802564660517371780402481729089536555417949051890403879840079255169295922593080322634775209689623239873322471161642996440906533187938298969649928516003704476137795166849228875",
+	}
+	ok := true
+	for n, want := range known {
+		if got := Nth(n).String(); got != want {
+			fmt.Printf("Nth(%d) = %s, want %s\n", n, got, want)
+			ok = false
+		}
+		if got := linear(n).String(); got != want {
+			fmt.Printf("linear(%d) = %s, want %s\n", n, got, want)
+			ok = false
+		}
+	}
+	// known values: true
+	fmt.Println("known values:", ok)
+
+	// F(10^6) has 208988 digits
+	fmt.Println("digits of F(1000000):", len(Nth(1_000_000).String()))
+
+	// Cassini: F(n-1)*F(n+1) - F(n)^2 = (-1)^n, and Nth agrees with linear
+	cassini := func(n uint16) bool {
+		k := uint(n)%5000 + 1
+		l := new(big.Int).Mul(Nth(k-1), Nth(k+1))
+		l.Sub(l, new(big.Int).Mul(Nth(k), Nth(k)))
+		return l.Int64() == 1-2*int64(k%2) && Nth(k).Cmp(linear(k)) == 0
+	}
+	// Cassini: <nil>
+	fmt.Println("Cassini:", quick.Check(cassini, &quick.Config{MaxCount: 200}))
+
+	// fast doubling is O(log n) big multiplications,
+	// stepping through the sequence O(n) big additions
+	for _, n := range []uint{100, 10_000, 100_000} {
+		n := n
+		fast := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Nth(n)
+			}
+		})
+		slow := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				linear(n)
+			}
+		})
+		fmt.Printf("F(%d): Nth %v/op, linear %v/op\n", n,
+			time.Duration(fast.NsPerOp()), time.Duration(slow.NsPerOp()))
+	}
+}
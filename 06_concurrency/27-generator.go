@@ -0,0 +1,185 @@
+/*
+ 1. `fibonacci(c, quit chan int)` in `5-select.go` needs its own quit channel,
+    and if the consumer forgets to send on it, the producer blocks forever
+ 2. a producer here is an `iter.Seq`: a function that calls `yield(v)`
+    for every value and returns once `yield` returns false;
+    `for v := range fibonacci` runs it without any goroutine,
+    and a `break` stops it
+ 3. `Generate` runs a producer in a goroutine and sends its values on a channel,
+    for consumers that need one (e.g. in a `select`);
+    cancelling the context makes the next `yield` return false,
+    and the channel is closed when the producer has returned;
+    a consumer that stops reading early must cancel the context,
+    or the producer stays blocked on its next send
+ 4. `Pull` is `iter.Pull` that also ends when a context is done:
+    `next()` returns the values one by one, `stop()` ends the producer
+ 5. `main` counts the goroutines before and after to prove nothing leaks
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"runtime"
+	"time"
+)
+
+// Generate runs p in a new goroutine and returns the channel of its values.
+// The channel is closed after p has returned,
+// which it does when it is done or when ctx is cancelled.
+// A consumer that stops reading before the channel is closed
+// must cancel ctx, or the goroutine leaks; ranging over p has no such trap.
+func Generate[T any](ctx context.Context, p iter.Seq[T]) <-chan T {
+	c := make(chan T)
+	go func() {
+		defer close(c)
+		p(func(v T) bool {
+			// do not send anything once the consumer is gone
+			if ctx.Err() != nil {
+				return false
+			}
+			select {
+			case c <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return c
+}
+
+// Pull returns an iterator over the values of p, like iter.Pull:
+// next returns the next value, or false once p is done, stopped,
+// or ctx is done. stop must be called when the caller is done with it.
+func Pull[T any](ctx context.Context, p iter.Seq[T]) (next func() (T, bool), stop func()) {
+	pnext, stop := iter.Pull(p)
+	next = func() (T, bool) {
+		if ctx.Err() != nil {
+			stop()
+			var zero T
+			return zero, false
+		}
+		return pnext()
+	}
+	return next, stop
+}
+
+// fibonacci of `5-select.go`, without the quit channel.
+func fibonacci(yield func(int) bool) {
+	x, y := 0, 1
+	for yield(x) {
+		x, y = y, x+y
+	}
+}
+
+// count yields 0 ... n-1.
+func count(n int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := 0; i < n && yield(i); i++ {
+		}
+	}
+}
+
+// oldFibonacci is `5-select.go`.
+func oldFibonacci(c, quit chan int) {
+	x, y := 0, 1
+	for {
+		select {
+		case c <- x:
+			x, y = y, x+y
+		case <-quit:
+			return
+		}
+	}
+}
+
+// settled waits up to a second for the number of goroutines to drop to n,
+// since a goroutine that was told to stop needs a moment to return.
+func settled(n int) int {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func main() {
+	before := runtime.NumGoroutine()
+
+	// 5-select.go with a context: 0 1 1 2 3 5 8 13 21 34
+	ctx, cancel := context.WithCancel(context.Background())
+	c := Generate(ctx, fibonacci)
+	for i := 0; i < 10; i++ {
+		fmt.Print(<-c, " ")
+	}
+	fmt.Println()
+	cancel()
+	// a value may still be in flight; the channel is closed
+	// once the producer has returned: 0 false
+	for range c {
+	}
+	v, ok := <-c
+	fmt.Println(v, ok)
+
+	// a finite producer closes the channel by itself: 0 1 2
+	for v := range Generate(context.Background(), count(3)) {
+		fmt.Print(v, " ")
+	}
+	fmt.Println()
+
+	// without a goroutine, breaking the loop stops the producer: 0 1 1 2 3 5
+	for v := range fibonacci {
+		fmt.Print(v, " ")
+		if v == 5 {
+			break
+		}
+	}
+	fmt.Println()
+
+	// as an iterator: 0 1 1 2 3
+	next, stop := Pull(context.Background(), fibonacci)
+	for i := 0; i < 5; i++ {
+		v, _ := next()
+		fmt.Print(v, " ")
+	}
+	fmt.Println()
+	stop()
+	// after stop: 0 false
+	fmt.Println(next())
+
+	// or after the context is done: 0 false
+	pctx, pcancel := context.WithCancel(context.Background())
+	next, stop = Pull(pctx, fibonacci)
+	next()
+	pcancel()
+	fmt.Println(next())
+	stop()
+
+	// a deadline stops a producer nobody reads from anymore
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n := 0
+	for range Generate(ctx, fibonacci) {
+		n++
+		if n == 3 {
+			// the consumer stops reading, but does not cancel:
+			// the producer is only stopped by the deadline
+			break
+		}
+	}
+	<-ctx.Done()
+
+	// leaked goroutines: 0
+	fmt.Println("leaked goroutines:", settled(before)-before)
+
+	// for comparison, 5-select.go when the consumer forgets to send on quit
+	oc, quit := make(chan int), make(chan int)
+	go oldFibonacci(oc, quit)
+	for i := 0; i < 10; i++ {
+		<-oc
+	}
+	// leaked goroutines without quit: 1
+	fmt.Println("leaked goroutines without quit:", settled(before)-before)
+}
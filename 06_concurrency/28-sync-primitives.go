@@ -0,0 +1,387 @@
+/*
+ 1. the "semaphore" of `9-exercise-web-crawler.go` is a `sync.WaitGroup`,
+    which only counts down, like the `Latch` here
+ 2. `Semaphore` is a weighted semaphore: `Acquire(ctx, n)` takes n units,
+    `Release(n)` gives them back; waiters are served first come, first served,
+    so a big request at the front is not overtaken by small ones behind it
+    and never starves; a cancelled `Acquire` takes nothing and leaves the queue,
+    and one for more than the size never joins it
+ 3. `Latch` opens once `CountDown` has been called n times, and stays open
+ 4. `Barrier` lets n goroutines wait for each other, then starts over;
+    a cancelled `Await` breaks the round for everyone in it
+ 5. `go run -race 28-sync-primitives.go` checks all three under load
+*/
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Semaphore is a weighted semaphore with FIFO waiters.
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List // of *semWaiter, oldest first
+}
+
+type semWaiter struct {
+	n     int64
+	ready chan struct{} // closed when the units are taken for it
+}
+
+// NewSemaphore returns a semaphore with size units.
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire takes n units, waiting until they are free or ctx is done.
+// On error it takes nothing. A request for more than the size
+// can never be served: it waits for ctx without queueing,
+// so it does not hold up the waiters behind it.
+// It panics if n is not positive.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	checkUnits(n)
+	if n > s.size {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	// nobody is overtaken, even if n would fit
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// got the units just now: give them back
+			s.cur -= n
+		default:
+			front := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// the ones behind may fit now that the front is gone
+			if !front {
+				s.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		s.notify()
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes n units if they are free and nobody is waiting,
+// and reports whether it did.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	checkUnits(n)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release gives back n units.
+func (s *Semaphore) Release(n int64) {
+	checkUnits(n)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		panic("semaphore: released more than held")
+	}
+	s.notify()
+}
+
+func checkUnits(n int64) {
+	if n <= 0 {
+		panic(fmt.Sprintf("semaphore: units must be positive, got %d", n))
+	}
+}
+
+// notify hands out units to the waiters in order, as long as they fit;
+// s.mu must be held.
+func (s *Semaphore) notify() {
+	for e := s.waiters.Front(); e != nil; e = s.waiters.Front() {
+		w := e.Value.(*semWaiter)
+		if s.size-s.cur < w.n {
+			// the front does not fit: the others wait behind it
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(e)
+		close(w.ready)
+	}
+}
+
+// waiting returns the number of waiters.
+func (s *Semaphore) waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len()
+}
+
+// Latch is opened by n calls to CountDown.
+type Latch struct {
+	mu    sync.Mutex
+	count int
+	open  chan struct{}
+}
+
+// NewLatch returns a latch that opens after n calls to CountDown.
+func NewLatch(n int) *Latch {
+	l := &Latch{count: n, open: make(chan struct{})}
+	if n <= 0 {
+		close(l.open)
+	}
+	return l
+}
+
+// CountDown counts one down; calls after the latch is open do nothing.
+func (l *Latch) CountDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return
+	}
+	l.count--
+	if l.count == 0 {
+		close(l.open)
+	}
+}
+
+// Count returns the number of CountDown calls still needed.
+func (l *Latch) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Wait waits until the latch is open or ctx is done.
+func (l *Latch) Wait(ctx context.Context) error {
+	select {
+	case <-l.open:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ErrBrokenBarrier is returned by Await when another goroutine
+// of the same round gave up.
+var ErrBrokenBarrier = errors.New("barrier: broken")
+
+// Barrier lets a fixed number of goroutines wait for each other, round after round.
+type Barrier struct {
+	mu      sync.Mutex
+	parties int
+	round   *round
+}
+
+type round struct {
+	arrived int
+	broken  bool
+	done    chan struct{} // closed when everybody arrived or the round broke
+}
+
+// NewBarrier returns a barrier for parties goroutines.
+func NewBarrier(parties int) *Barrier {
+	return &Barrier{parties: parties, round: &round{done: make(chan struct{})}}
+}
+
+// Await waits until all parties have called Await, then the barrier starts over.
+// If ctx is done first, the round is broken: everybody waiting in it
+// gets ErrBrokenBarrier, and the caller gets ctx.Err().
+func (b *Barrier) Await(ctx context.Context) error {
+	b.mu.Lock()
+	r := b.round
+	r.arrived++
+	if r.arrived == b.parties {
+		// the last one lets everybody go
+		b.round = &round{done: make(chan struct{})}
+		close(r.done)
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-r.done:
+		if r.broken {
+			return ErrBrokenBarrier
+		}
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-r.done:
+			// everybody arrived just now
+			if r.broken {
+				return ErrBrokenBarrier
+			}
+			return nil
+		default:
+		}
+		r.broken = true
+		b.round = &round{done: make(chan struct{})}
+		close(r.done)
+		return ctx.Err()
+	}
+}
+
+// waiting returns the number of goroutines waiting in the current round.
+func (b *Barrier) waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.round.arrived
+}
+
+// waitFor polls until cond is true, to line up goroutines in the demos.
+func waitFor(cond func() bool) {
+	for !cond() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func main() {
+	ctx := context.Background()
+
+	// fairness: with all 10 units taken, a waiter for 5 queues up,
+	// and a later request for 1 does not overtake it
+	s := NewSemaphore(10)
+	s.Acquire(ctx, 10)
+	var order []string
+	var mu sync.Mutex
+	got := func(name string) {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { s.Acquire(ctx, 5); got("five"); s.Release(5); wg.Done() }()
+	waitFor(func() bool { return s.waiting() == 1 })
+	go func() { s.Acquire(ctx, 1); got("one"); s.Release(1); wg.Done() }()
+	waitFor(func() bool { return s.waiting() == 2 })
+	s.Release(1)
+	// false: one unit is free, but "five" is first in line
+	fmt.Println(s.TryAcquire(1))
+	// 5 units free: "five" goes, "one" waits until it is done
+	s.Release(4)
+	wg.Wait()
+	// [five one]
+	fmt.Println(order)
+	s.Release(5)
+
+	// a cancelled waiter at the front lets the ones behind it through
+	s.Acquire(ctx, 8)
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	errs := make(chan error)
+	go func() { errs <- s.Acquire(tctx, 5) }()
+	waitFor(func() bool { return s.waiting() == 1 })
+	go func() { errs <- s.Acquire(ctx, 2) }()
+	waitFor(func() bool { return s.waiting() == 2 })
+	// context deadline exceeded
+	fmt.Println(<-errs)
+	// <nil>
+	fmt.Println(<-errs)
+	cancel()
+	s.Release(10)
+
+	// a request for more than the size does not queue up,
+	// so it holds up nobody: <nil> context deadline exceeded
+	tctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	go func() { errs <- s.Acquire(tctx, 11) }()
+	fmt.Println(s.Acquire(ctx, 1), <-errs)
+	cancel()
+	s.Release(1)
+
+	// stress: never more than 10 units in use
+	var inUse, most atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < 200; j++ {
+				n := int64(r.Intn(10) + 1)
+				if err := s.Acquire(ctx, n); err != nil {
+					panic(err)
+				}
+				u := inUse.Add(n)
+				for m := most.Load(); u > m && !most.CompareAndSwap(m, u); m = most.Load() {
+				}
+				inUse.Add(-n)
+				s.Release(n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// semaphore: at most 10 in use: true
+	fmt.Println("semaphore: at most 10 in use:", most.Load() <= 10)
+
+	// latch: the crawler's WaitGroup, counted down by 3 workers
+	l := NewLatch(3)
+	for i := 0; i < 3; i++ {
+		go l.CountDown()
+	}
+	// <nil> 0
+	fmt.Println(l.Wait(ctx), l.Count())
+
+	// barrier: 4 goroutines, 100 rounds; nobody starts round k+1
+	// before everybody has finished round k
+	const parties, rounds = 4, 100
+	b := NewBarrier(parties)
+	var done [rounds]atomic.Int32
+	var early atomic.Bool
+	for p := 0; p < parties; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < rounds; k++ {
+				if k > 0 && done[k-1].Load() != parties {
+					early.Store(true)
+				}
+				done[k].Add(1)
+				if err := b.Await(ctx); err != nil {
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// barrier: in step: true
+	fmt.Println("barrier: in step:", !early.Load())
+
+	// a goroutine that gives up breaks the round for the others
+	b = NewBarrier(3)
+	go func() { errs <- b.Await(ctx) }()
+	waitFor(func() bool { return b.waiting() == 1 })
+	tctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	// context deadline exceeded
+	fmt.Println(b.Await(tctx))
+	// barrier: broken
+	fmt.Println(<-errs)
+}
@@ -0,0 +1,338 @@
+/*
+ 1. `x, y := <-c, <-c` in `2-channels.go` gets the two sums in whatever
+    order the goroutines finish; a `Future[T]` is the result of one
+    particular goroutine
+ 2. `Go` starts the function and returns its future at once;
+    `Await(ctx)` waits for the value and error (or gives up when ctx is done),
+    and may be called any number of times, always with the same result
+ 3. the result is set exactly once: by the function, or by `Cancel`,
+    whichever comes first; a panic becomes the error
+ 4. `Then` chains a function onto a future;
+    `All` waits for every future, `Any` for the first success,
+    `Race` for the first result; the losers are cancelled;
+    without futures, `All` gives an empty slice, `Any` and `Race` an error
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Future is the result of a function running in its own goroutine.
+type Future[T any] struct {
+	once   sync.Once
+	done   chan struct{} // closed once val and err are set
+	val    T
+	err    error
+	cancel context.CancelFunc
+}
+
+func newFuture[T any](cancel context.CancelFunc) *Future[T] {
+	return &Future[T]{done: make(chan struct{}), cancel: cancel}
+}
+
+// resolve sets the result, unless it is set already.
+func (f *Future[T]) resolve(v T, err error) {
+	f.once.Do(func() {
+		f.val, f.err = v, err
+		f.cancel()
+		close(f.done)
+	})
+}
+
+// Go runs fn in a new goroutine.
+func Go[T any](fn func() (T, error)) *Future[T] {
+	return GoContext(context.Background(), func(context.Context) (T, error) { return fn() })
+}
+
+// GoContext runs fn in a new goroutine with a context
+// that is cancelled by Cancel, or when ctx is done.
+func GoContext[T any](ctx context.Context, fn func(context.Context) (T, error)) *Future[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	f := newFuture[T](cancel)
+	go func() {
+		var v T
+		var err error
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("future: panic: %v", p)
+			}
+			f.resolve(v, err)
+		}()
+		v, err = fn(ctx)
+	}()
+	return f
+}
+
+// Done returns a channel that is closed once the result is set.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Await returns the result, waiting for it until ctx is done.
+// Giving up does not cancel the future.
+func (f *Future[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Cancel cancels the context of the function and, unless the result
+// is already set, sets it to context.Canceled.
+func (f *Future[T]) Cancel() {
+	var zero T
+	f.resolve(zero, context.Canceled)
+}
+
+// Then returns the future of fn applied to the value of f.
+// An error of f is passed on without calling fn;
+// cancelling the new future cancels f too.
+func Then[T, U any](f *Future[T], fn func(T) (U, error)) *Future[U] {
+	return GoContext(context.Background(), func(ctx context.Context) (U, error) {
+		v, err := f.Await(ctx)
+		if err != nil {
+			f.Cancel()
+			var zero U
+			return zero, err
+		}
+		return fn(v)
+	})
+}
+
+// ErrNoFutures is the result of Any and Race without futures.
+var ErrNoFutures = errors.New("future: no futures")
+
+// All returns the future of all the values, in the order of fs.
+// The first error is the result, and cancels the others.
+// Without futures, the result is an empty slice.
+func All[T any](fs ...*Future[T]) *Future[[]T] {
+	fs = append([]*Future[T](nil), fs...)
+	return GoContext(context.Background(), func(ctx context.Context) ([]T, error) {
+		defer cancelAll(fs)
+		vals := make([]T, len(fs))
+		results := fanIn(ctx, fs)
+		for range fs {
+			r, err := next(ctx, results)
+			if err == nil {
+				err = r.err
+			}
+			if err != nil {
+				return nil, err
+			}
+			vals[r.i] = r.v
+		}
+		return vals, nil
+	})
+}
+
+// AnyError is the result of Any when all futures fail.
+type AnyError []error
+
+func (e AnyError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "all futures failed: " + strings.Join(msgs, "; ")
+}
+
+// Any returns the future of the first value without error;
+// if all fail, the result is an AnyError. The others are cancelled.
+func Any[T any](fs ...*Future[T]) *Future[T] {
+	fs = append([]*Future[T](nil), fs...)
+	return GoContext(context.Background(), func(ctx context.Context) (T, error) {
+		defer cancelAll(fs)
+		var zero T
+		if len(fs) == 0 {
+			return zero, ErrNoFutures
+		}
+		errs := make(AnyError, len(fs))
+		results := fanIn(ctx, fs)
+		for range fs {
+			r, err := next(ctx, results)
+			if err != nil {
+				return zero, err
+			}
+			if r.err == nil {
+				return r.v, nil
+			}
+			errs[r.i] = r.err
+		}
+		return zero, errs
+	})
+}
+
+// Race returns the future of the first result, value or error.
+// The others are cancelled.
+func Race[T any](fs ...*Future[T]) *Future[T] {
+	fs = append([]*Future[T](nil), fs...)
+	return GoContext(context.Background(), func(ctx context.Context) (T, error) {
+		defer cancelAll(fs)
+		var zero T
+		if len(fs) == 0 {
+			return zero, ErrNoFutures
+		}
+		r, err := next(ctx, fanIn(ctx, fs))
+		if err != nil {
+			return zero, err
+		}
+		return r.v, r.err
+	})
+}
+
+// indexed is the result of the future at index i of a combinator.
+type indexed[T any] struct {
+	i   int
+	v   T
+	err error
+}
+
+// fanIn sends the result of every future as it comes in,
+// with one goroutine per future, like Merge in 18-pipeline.go.
+// The goroutines give up once ctx is done.
+func fanIn[T any](ctx context.Context, fs []*Future[T]) <-chan indexed[T] {
+	c := make(chan indexed[T])
+	for i, f := range fs {
+		go func(i int, f *Future[T]) {
+			v, err := f.Await(ctx)
+			select {
+			case c <- indexed[T]{i, v, err}:
+			case <-ctx.Done():
+			}
+		}(i, f)
+	}
+	return c
+}
+
+// next returns the next result of c, or the error of ctx.
+func next[T any](ctx context.Context, c <-chan indexed[T]) (indexed[T], error) {
+	select {
+	case r := <-c:
+		return r, nil
+	case <-ctx.Done():
+		return indexed[T]{}, ctx.Err()
+	}
+}
+
+func cancelAll[T any](fs []*Future[T]) {
+	for _, f := range fs {
+		f.Cancel()
+	}
+}
+
+// sum of `2-channels.go`, returning its result.
+func sum(s []int) (int, error) {
+	sum := 0
+	for _, v := range s {
+		sum += v
+	}
+	return sum, nil
+}
+
+// after returns v after d, or the error of ctx.
+func after[T any](d time.Duration, v T, err error) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		select {
+		case <-time.After(d):
+			return v, err
+		case <-ctx.Done():
+			return v, ctx.Err()
+		}
+	}
+}
+
+func main() {
+	ctx := context.Background()
+
+	// 2-channels.go: always 17 -5 12
+	s := []int{7, 2, 8, -9, 4, 0}
+	fx := Go(func() (int, error) { return sum(s[:len(s)/2]) })
+	fy := Go(func() (int, error) { return sum(s[len(s)/2:]) })
+	x, _ := fx.Await(ctx)
+	y, _ := fy.Await(ctx)
+	fmt.Println(x, y, x+y)
+
+	// Then: total 12 <nil>
+	total := Then(All(fx, fy), func(xs []int) (string, error) {
+		return fmt.Sprint("total ", xs[0]+xs[1]), nil
+	})
+	fmt.Println(total.Await(ctx))
+
+	// exactly once: 100 awaiters and a late Cancel all see the same result
+	var same atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := fx.Await(ctx); v == 17 && err == nil {
+				same.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	fx.Cancel()
+	v, err := fx.Await(ctx)
+	// 100 17 <nil>
+	fmt.Println(same.Load(), v, err)
+
+	// a panic is an error: 0 future: panic: runtime error: index out of range [10] with length 6
+	fmt.Println(Go(func() (int, error) { return s[len(s)+4], nil }).Await(ctx))
+
+	// Any: the first success, failures are skipped: fast <nil>
+	failed := errors.New("failed")
+	fmt.Println(Any(
+		GoContext(ctx, after(time.Millisecond, "", failed)),
+		GoContext(ctx, after(10*time.Millisecond, "fast", nil)),
+		GoContext(ctx, after(time.Second, "slow", nil)),
+	).Await(ctx))
+	// all failed: all futures failed: failed; failed
+	_, err = Any(
+		GoContext(ctx, after(time.Millisecond, "", failed)),
+		GoContext(ctx, after(2*time.Millisecond, "", failed)),
+	).Await(ctx)
+	fmt.Println(err)
+
+	// Race: the first result, even an error: "" failed
+	slow := GoContext(ctx, after(time.Second, "slow", nil))
+	start := time.Now()
+	r, err := Race(GoContext(ctx, after(time.Millisecond, "", failed)), slow).Await(ctx)
+	fmt.Printf("%q %v\n", r, err)
+	// and the loser is cancelled: "" context canceled
+	r, err = slow.Await(ctx)
+	fmt.Printf("%q %v\n", r, err)
+
+	// All: the first error cancels the others: [] failed
+	fmt.Println(All(
+		GoContext(ctx, after(time.Second, "slow", nil)),
+		GoContext(ctx, after(time.Millisecond, "", failed)),
+	).Await(ctx))
+
+	// without futures: [] <nil>, then future: no futures, twice
+	fmt.Println(All[int]().Await(ctx))
+	_, err = Any[int]().Await(ctx)
+	fmt.Println(err)
+	_, err = Race[int]().Await(ctx)
+	fmt.Println(err)
+
+	// Await gives up, the future keeps running: context deadline exceeded, then late <nil>
+	late := GoContext(ctx, after(20*time.Millisecond, "late", nil))
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = late.Await(tctx)
+	fmt.Println(err)
+	fmt.Println(late.Await(ctx))
+
+	// nothing waited for the second-long futures: true
+	fmt.Println(time.Since(start) < 500*time.Millisecond)
+}
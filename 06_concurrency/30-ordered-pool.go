@@ -0,0 +1,257 @@
+/*
+ 1. `2-channels.go` gets its sums back in the order the goroutines finish
+    ("sometimes 17 -5 12, and sometimes -5 17 12")
+ 2. `Ordered` runs a function on a stream of inputs with N workers,
+    and emits the results in the order of the inputs
+ 3. results that are done early wait in a reorder buffer;
+    at most `window` inputs are taken in before their result is emitted,
+    so one slow input holds up at most `window` others, and memory stays bounded
+ 4. an error belongs to its input: it is emitted in its place,
+    and the other inputs go on
+ 5. cancelling the context stops all the goroutines, and the output is closed
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Result is the output of the function for the input at Index.
+type Result[Out any] struct {
+	Index int
+	Value Out
+	Err   error
+}
+
+// Ordered applies fn to every value of in with the given number of workers,
+// and sends the results on the returned channel in input order.
+// At most window inputs are in progress or waiting to be emitted.
+// The channel is closed once in is closed and all results are sent,
+// or once ctx is done and all goroutines have returned.
+// It panics if workers or window is less than 1.
+func Ordered[In, Out any](ctx context.Context, in <-chan In, workers, window int,
+	fn func(context.Context, In) (Out, error)) <-chan Result[Out] {
+
+	if workers < 1 || window < 1 {
+		panic("Ordered: workers and window must be at least 1")
+	}
+	type job struct {
+		i int
+		v In
+	}
+	// a token for each input taken in, given back once its result is emitted
+	tokens := make(chan struct{}, window)
+	jobs := make(chan job)
+	results := make(chan Result[Out])
+	out := make(chan Result[Out])
+
+	// take in the inputs, numbering them
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			var v In
+			var ok bool
+			select {
+			case v, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- job{i, v}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				v, err := fn(ctx, j.v)
+				select {
+				case results <- Result[Out]{j.i, v, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// emit in order, keeping the early ones in the reorder buffer
+	go func() {
+		defer close(out)
+		pending := make(map[int]Result[Out])
+		next := 0
+		for r := range results {
+			pending[r.Index] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					// drop the rest; the workers return too, which closes results
+					for range results {
+					}
+					return
+				}
+				delete(pending, next)
+				next++
+				<-tokens
+			}
+		}
+	}()
+	return out
+}
+
+// sum of `2-channels.go`, with a random delay.
+func sum(ctx context.Context, s []int) (int, error) {
+	select {
+	case <-time.After(time.Duration(rand.Intn(10)) * time.Millisecond):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	sum := 0
+	for _, v := range s {
+		sum += v
+	}
+	return sum, nil
+}
+
+// send sends the values on a new channel and closes it,
+// or gives up when ctx is done.
+func send[T any](ctx context.Context, vs ...T) <-chan T {
+	c := make(chan T)
+	go func() {
+		defer close(c)
+		for _, v := range vs {
+			select {
+			case c <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func main() {
+	ctx := context.Background()
+	before := runtime.NumGoroutine()
+
+	// 2-channels.go: always 17 -5 12
+	s := []int{7, 2, 8, -9, 4, 0}
+	var sums []int
+	for r := range Ordered(ctx, send(ctx, s[:len(s)/2], s[len(s)/2:]), 2, 2, sum) {
+		sums = append(sums, r.Value)
+	}
+	fmt.Println(sums[0], sums[1], sums[0]+sums[1])
+
+	// 100 inputs, 8 workers, random delays and a few errors: still in order
+	var mu sync.Mutex
+	running, most := 0, 0
+	square := func(ctx context.Context, n int) (int, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		if n%25 == 13 {
+			return 0, fmt.Errorf("unlucky %d", n)
+		}
+		return n * n, nil
+	}
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+	inOrder := true
+	var errs []error
+	for r := range Ordered(ctx, send(ctx, in...), 8, 16, square) {
+		if r.Err != nil {
+			// the errors are in their places, the other inputs go on
+			errs = append(errs, r.Err)
+			continue
+		}
+		if r.Value != r.Index*r.Index {
+			inOrder = false
+		}
+	}
+	// in order: true, at most 8 running
+	fmt.Printf("in order: %v, at most %d running\n", inOrder, most)
+	// [unlucky 13 unlucky 38 unlucky 63 unlucky 88]
+	fmt.Println(errs)
+
+	// the window bounds the reorder buffer: the first input is slow,
+	// so only 4 inputs are taken in until it is done
+	var taken []int
+	slowFirst := func(ctx context.Context, n int) (int, error) {
+		mu.Lock()
+		taken = append(taken, n)
+		mu.Unlock()
+		if n == 0 {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			// taken while 0 was running: 4
+			fmt.Println("taken while 0 was running:", len(taken))
+		}
+		return n, nil
+	}
+	for range Ordered(ctx, send(ctx, in[:10]...), 4, 4, slowFirst) {
+	}
+
+	// cancelling in the middle stops everything
+	cctx, cancel := context.WithCancel(ctx)
+	n := 0
+	for r := range Ordered(cctx, send(cctx, in...), 4, 8, square) {
+		n++
+		if r.Index == 9 {
+			cancel()
+		}
+	}
+	// stopped early: true
+	fmt.Println("stopped early:", n < len(in))
+
+	// an input that is never closed: cancelling still closes the output
+	cctx, cancel = context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	for range Ordered(cctx, make(chan int), 4, 8, square) {
+	}
+	// closed after cancel: context canceled
+	fmt.Println("closed after cancel:", cctx.Err())
+
+	// no goroutines left behind
+	time.Sleep(10 * time.Millisecond)
+	// leaked goroutines: 0
+	fmt.Println("leaked goroutines:", runtime.NumGoroutine()-before)
+}
@@ -0,0 +1,221 @@
+/*
+ 1. a third send in `3-buffered-channels.go` blocks forever, and all the
+    runtime can say is "all goroutines are asleep - deadlock!",
+    and only if really every goroutine is stuck
+ 2. `Chan[T]` wraps a channel; a `Send` or `Recv` that cannot go on at once
+    is recorded (channel, operation, goroutine, call site, since when)
+    until it does
+ 3. `Dump` writes who is waiting on what, the longest wait first;
+    `-serve` makes it available at /debug/chans,
+    and on SIGQUIT (ctrl-\) instead of the usual goroutine dump
+ 4. operations that do not block only cost a `select` with a `default` case,
+    as in `6-default.go`; a plain `select` on `C()` is not recorded
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// wait is a blocked send or receive.
+type wait struct {
+	id        uint64
+	ch        string
+	op        string
+	goroutine int
+	site      string // file:line of the caller
+	since     time.Time
+}
+
+// waits are the blocked operations of all channels.
+var waits = struct {
+	sync.Mutex
+	next uint64
+	m    map[uint64]*wait
+}{m: make(map[uint64]*wait)}
+
+// block records that the caller of the caller is about to block,
+// and returns the function that removes the record.
+func block(ch, op string) (done func()) {
+	_, file, line, _ := runtime.Caller(2)
+	w := &wait{
+		ch:        ch,
+		op:        op,
+		goroutine: goroutineID(),
+		site:      fmt.Sprintf("%s:%d", filepath.Base(file), line),
+		since:     time.Now(),
+	}
+	waits.Lock()
+	waits.next++
+	w.id = waits.next
+	waits.m[w.id] = w
+	waits.Unlock()
+	return func() {
+		waits.Lock()
+		delete(waits.m, w.id)
+		waits.Unlock()
+	}
+}
+
+// goroutineID reads the id from the first line of the stack,
+// "goroutine 18 [running]:"; it is only meant for diagnostics.
+func goroutineID() int {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	id, _ := strconv.Atoi(string(b[:bytes.IndexByte(b, ' ')]))
+	return id
+}
+
+// Chan is a channel that records its blocked senders and receivers.
+type Chan[T any] struct {
+	name string
+	c    chan T
+}
+
+// NewChan returns a channel with a buffer of size, named in the dumps.
+func NewChan[T any](name string, size int) *Chan[T] {
+	return &Chan[T]{name: name, c: make(chan T, size)}
+}
+
+// Send sends v, recording the wait if the channel is full.
+func (c *Chan[T]) Send(v T) {
+	select {
+	case c.c <- v:
+		return
+	default:
+	}
+	done := block(c.name, "send")
+	// deferred, so a send on a closed channel does not leave its record behind
+	defer done()
+	c.c <- v
+}
+
+// Recv receives a value, recording the wait if the channel is empty.
+// ok is false once the channel is closed and empty.
+func (c *Chan[T]) Recv() (v T, ok bool) {
+	select {
+	case v, ok = <-c.c:
+		return v, ok
+	default:
+	}
+	done := block(c.name, "recv")
+	defer done()
+	v, ok = <-c.c
+	return v, ok
+}
+
+// Close closes the channel.
+func (c *Chan[T]) Close() { close(c.c) }
+
+// C returns the channel itself, for select; waits on it are not recorded.
+func (c *Chan[T]) C() chan T { return c.c }
+
+// Len returns the number of buffered values.
+func (c *Chan[T]) Len() int { return len(c.c) }
+
+// Dump writes the blocked operations, the longest wait first.
+func Dump(w io.Writer) {
+	waits.Lock()
+	list := make([]*wait, 0, len(waits.m))
+	for _, w := range waits.m {
+		list = append(list, w)
+	}
+	waits.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].since.Before(list[j].since) })
+
+	if len(list) == 0 {
+		fmt.Fprintln(w, "no blocked channel operations")
+		return
+	}
+	now := time.Now()
+	for _, b := range list {
+		fmt.Fprintf(w, "goroutine %d: %s on %q blocked for %v at %s\n",
+			b.goroutine, b.op, b.ch, now.Sub(b.since).Round(time.Millisecond), b.site)
+	}
+}
+
+// handler serves the dump, for /debug/chans.
+func handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	Dump(w)
+}
+
+// dumpOnQuit writes the dump to stderr on every SIGQUIT.
+func dumpOnQuit() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+	for range quit {
+		Dump(os.Stderr)
+	}
+}
+
+// hang starts 3-buffered-channels.go with a third send,
+// and a receiver waiting on a channel nobody sends on.
+func hang() (ch *Chan[int], unblock func()) {
+	ch = NewChan[int]("buffered", 2)
+	ch.Send(1)
+	ch.Send(2)
+	go func() { ch.Send(3) }()
+
+	idle := NewChan[string]("idle", 0)
+	go func() { idle.Recv() }()
+	return ch, idle.Close
+}
+
+func main() {
+	addr := flag.String("serve", "", "serve /debug/chans on this address, and dump on SIGQUIT")
+	flag.Parse()
+
+	if *addr != "" {
+		hang()
+		go dumpOnQuit()
+		http.HandleFunc("/debug/chans", handler)
+		log.Printf("Serving http://%s/debug/chans, or press ctrl-\\", *addr)
+		log.Fatal(http.ListenAndServe(*addr, nil))
+	}
+
+	// nothing blocks yet
+	Dump(os.Stdout)
+
+	ch, unblock := hang()
+	time.Sleep(50 * time.Millisecond)
+
+	// goroutine 7: send on "buffered" blocked for 50ms at 31-chan-diagnostics.go:174
+	// goroutine 8: recv on "idle" blocked for 50ms at 31-chan-diagnostics.go:177
+	Dump(os.Stdout)
+
+	// the same over HTTP
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/debug/chans")
+	if err != nil {
+		log.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	// 2 blocked
+	fmt.Println(bytes.Count(body, []byte("blocked")), "blocked")
+
+	// receiving makes room for the third send, closing wakes up the receiver: 1 true
+	fmt.Println(ch.Recv())
+	unblock()
+	time.Sleep(10 * time.Millisecond)
+	// no blocked channel operations
+	Dump(os.Stdout)
+}
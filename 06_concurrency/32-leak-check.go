@@ -0,0 +1,303 @@
+/*
+ 1. `Same` returns as soon as the trees differ, and leaves both walkers
+    blocked on their channels; `fibonacci` of `5-select.go` blocks forever
+    if nobody sends on quit; a crawl that gives up waiting leaves its
+    fetchers running
+ 2. `CheckLeaks(t, grace)` remembers the goroutines at the start of a test;
+    when the test is cleaned up, it waits up to grace for the new ones
+    to return, and reports the ones still there, with their stacks
+ 3. `t` is anything with `Helper`, `Cleanup` and `Errorf`, such as
+    `*testing.T` and `*testing.B`: in a test file it is just
+    `CheckLeaks(t, time.Second)` as the first line of the test
+ 4. this project has no test files, so `main` runs the leak tests
+    with a small stand-in for `*testing.T`
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tour/tree"
+)
+
+// TB is the part of `testing.TB` that CheckLeaks needs.
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...any)
+}
+
+// goroutines returns the stacks of all goroutines, by goroutine id.
+func goroutines() map[string]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		// "goroutine 18 [chan send]:"
+		fields := strings.Fields(string(stack))
+		if len(fields) > 1 && fields[0] == "goroutine" {
+			stacks[fields[1]] = strings.TrimSpace(string(stack))
+		}
+	}
+	return stacks
+}
+
+// CheckLeaks fails t if goroutines started during the test
+// are still running grace after it is done.
+func CheckLeaks(t TB, grace time.Duration) {
+	t.Helper()
+	before := goroutines()
+	t.Cleanup(func() {
+		var leaked []string
+		deadline := time.Now().Add(grace)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(leaked) > 0 {
+			sort.Strings(leaked)
+			t.Errorf("%d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// fakeT stands in for *testing.T.
+type fakeT struct {
+	name     string
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Helper()          {}
+func (t *fakeT) Cleanup(f func()) { t.cleanups = append(t.cleanups, f) }
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// run runs test like `go test` would, cleanups last-in first-out,
+// and prints the result; with verbose, the errors in full.
+func run(name string, test func(t TB), verbose bool) {
+	t := &fakeT{name: name}
+	test(t)
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+	if len(t.errors) == 0 {
+		fmt.Println("--- PASS:", name)
+		return
+	}
+	fmt.Println("--- FAIL:", name)
+	for _, e := range t.errors {
+		if verbose {
+			fmt.Println(e)
+			continue
+		}
+		// just the first line and the leaked functions
+		lines := strings.Split(e, "\n")
+		fmt.Println("   ", lines[0])
+		for i, line := range lines {
+			if strings.HasPrefix(line, "goroutine ") && i+1 < len(lines) {
+				fmt.Println("       ", lines[i+1])
+			}
+		}
+	}
+}
+
+// Walk and Same of `7-exercise-equivalent-binary-trees.go`.
+func Walk(t *tree.Tree, ch chan int) {
+	var walker func(t *tree.Tree)
+	walker = func(t *tree.Tree) {
+		if t == nil {
+			return
+		}
+		walker(t.Left)
+		ch <- t.Value
+		walker(t.Right)
+	}
+	walker(t)
+	close(ch)
+}
+
+func Same(t1, t2 *tree.Tree) bool {
+	ch1, ch2 := make(chan int), make(chan int)
+	go Walk(t1, ch1)
+	go Walk(t2, ch2)
+	for {
+		v1, ok1 := <-ch1
+		v2, ok2 := <-ch2
+		if v1 != v2 || ok1 != ok2 {
+			return false
+		}
+		if !ok1 {
+			break
+		}
+	}
+	return true
+}
+
+// SameNoLeak drains both walkers before it returns.
+func SameNoLeak(t1, t2 *tree.Tree) bool {
+	ch1, ch2 := make(chan int), make(chan int)
+	go Walk(t1, ch1)
+	go Walk(t2, ch2)
+	defer func() {
+		for range ch1 {
+		}
+		for range ch2 {
+		}
+	}()
+	for {
+		v1, ok1 := <-ch1
+		v2, ok2 := <-ch2
+		if v1 != v2 || ok1 != ok2 {
+			return false
+		}
+		if !ok1 {
+			return true
+		}
+	}
+}
+
+// fibonacci of `5-select.go`.
+func fibonacci(c, quit chan int) {
+	x, y := 0, 1
+	for {
+		select {
+		case c <- x:
+			x, y = y, x+y
+		case <-quit:
+			return
+		}
+	}
+}
+
+// slowFetcher takes its time for every page, like a real backend.
+type slowFetcher time.Duration
+
+func (f slowFetcher) Fetch(url string) (string, []string, error) {
+	time.Sleep(time.Duration(f))
+	return url, []string{url + "a/", url + "b/"}, nil
+}
+
+// crawl of `9-exercise-web-crawler.go`, without the global wait group,
+// and giving up after timeout: reports whether it finished.
+func crawl(url string, depth int, f slowFetcher, timeout time.Duration) bool {
+	var wg sync.WaitGroup
+	var visit func(url string, depth int)
+	visit = func(url string, depth int) {
+		defer wg.Done()
+		if depth <= 0 {
+			return
+		}
+		_, urls, _ := f.Fetch(url)
+		for _, u := range urls {
+			wg.Add(1)
+			go visit(u, depth-1)
+		}
+	}
+	wg.Add(1)
+	go visit(url, depth)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func main() {
+	const grace = 100 * time.Millisecond
+
+	// --- PASS: TestSameEqual
+	run("TestSameEqual", func(t TB) {
+		CheckLeaks(t, grace)
+		Same(tree.New(1), tree.New(1))
+	}, false)
+	// the walkers of the differing trees stay blocked, shown in full:
+	// --- FAIL: TestSameDifferent
+	// 2 goroutine(s) leaked:
+	//
+	// goroutine 8 [chan send]:
+	// main.Walk.func1(0xc0000165d0)
+	// 	32-leak-check.go:135 +0xa5
+	// ...
+	// created by main.Same in goroutine 1
+	// 	32-leak-check.go:144 +0xfa
+	// ...
+	run("TestSameDifferent", func(t TB) {
+		CheckLeaks(t, grace)
+		Same(tree.New(1), tree.New(2))
+	}, true)
+	// --- PASS: TestSameNoLeakDifferent
+	run("TestSameNoLeakDifferent", func(t TB) {
+		CheckLeaks(t, grace)
+		SameNoLeak(tree.New(1), tree.New(2))
+	}, false)
+
+	// --- PASS: TestFibonacciQuit
+	run("TestFibonacciQuit", func(t TB) {
+		CheckLeaks(t, grace)
+		c, quit := make(chan int), make(chan int)
+		go func() {
+			for i := 0; i < 10; i++ {
+				<-c
+			}
+			quit <- 0
+		}()
+		fibonacci(c, quit)
+	}, false)
+	// --- FAIL: TestFibonacciNoQuit
+	//     1 goroutine(s) leaked:
+	//         main.fibonacci(...)
+	run("TestFibonacciNoQuit", func(t TB) {
+		CheckLeaks(t, grace)
+		c, quit := make(chan int), make(chan int)
+		go fibonacci(c, quit)
+		for i := 0; i < 10; i++ {
+			<-c
+		}
+	}, false)
+
+	// a crawl that finishes within the grace period passes, even if slow:
+	// --- PASS: TestCrawl
+	run("TestCrawl", func(t TB) {
+		CheckLeaks(t, grace)
+		crawl("https://golang.org/", 3, slowFetcher(time.Millisecond), time.Second)
+	}, false)
+	// the fetchers and the waiter outlive a crawl that gave up:
+	// --- FAIL: TestCrawlTimeout
+	//     2 goroutine(s) leaked:
+	//         time.Sleep(0x3b9aca00)
+	//         sync.runtime_SemacquireWaitGroup(...)
+	run("TestCrawlTimeout", func(t TB) {
+		CheckLeaks(t, grace)
+		crawl("https://golang.org/", 3, slowFetcher(time.Second), time.Millisecond)
+	}, false)
+}
@@ -0,0 +1,418 @@
+/*
+ 1. "Don't communicate by sharing memory, share memory by communicating."
+    (the quote of `00_hello_world_mod`); `SafeCounter` of `8-mutex-counter.go`
+    shares its map behind a mutex, the actor here owns it
+ 2. an actor handles the messages of its mailbox one at a time,
+    in its own goroutine, so its state needs no lock;
+    `Spawn` starts one and returns a `Ref` to it,
+    `Send` puts a message into the mailbox (waiting while it is full),
+    and `Ask` sends a message with a reply channel and waits for the answer,
+    or gives up after a timeout
+ 3. a panic in `Receive` is handled by the supervision strategy
+    Resume   drop the message, keep the state
+    Restart  start over with a fresh actor, at most MaxRestarts times Within
+    Stop     stop the actor
+ 4. `Stop` stops taking messages, handles the ones already in the mailbox,
+    calls `Stopped` if the actor has it, and waits for all that;
+    a `Send` still waiting for room in the mailbox gives up with `ErrStopped`
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Actor handles messages of type M.
+type Actor[M any] interface {
+	Receive(m M)
+}
+
+// Stopper is an actor that wants to know when it is stopped.
+type Stopper interface {
+	Stopped()
+}
+
+// Strategy says what to do when Receive panics.
+type Strategy int
+
+const (
+	Resume Strategy = iota
+	Restart
+	Stop
+)
+
+func (s Strategy) String() string {
+	return [...]string{"Resume", "Restart", "Stop"}[s]
+}
+
+// Supervision is the policy of an actor.
+type Supervision struct {
+	Strategy    Strategy
+	MaxRestarts int           // with Restart, the actor stops after more restarts
+	Within      time.Duration // than MaxRestarts in this window
+}
+
+var (
+	// ErrStopped is returned by Send after the actor has stopped.
+	ErrStopped = errors.New("actor: stopped")
+	// ErrTimeout is returned by Ask when no answer came in time.
+	ErrTimeout = errors.New("actor: ask timed out")
+)
+
+// PanicError is why an actor stopped after a panic.
+type PanicError struct {
+	Value any
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("actor: panic: %v", p.Value)
+}
+
+// Ref is the address of an actor.
+type Ref[M any] struct {
+	name    string
+	mailbox chan M
+
+	mu       sync.Mutex
+	stopped  bool
+	sending  int           // Sends that may still put a message in the mailbox
+	stopping chan struct{} // closed by Stop, or after a fatal panic
+	quiet    chan struct{} // closed once stopped and no Send is left
+	done     chan struct{} // closed when the goroutine has returned
+
+	restarts atomic.Int32
+	err      error // set before done is closed
+}
+
+// Spawn starts an actor made by factory, with a mailbox of size messages.
+// factory is called again on every restart.
+func Spawn[M any](name string, size int, factory func() Actor[M], sup Supervision) *Ref[M] {
+	r := &Ref[M]{
+		name:     name,
+		mailbox:  make(chan M, size),
+		stopping: make(chan struct{}),
+		quiet:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.loop(factory, sup)
+	return r
+}
+
+// Send puts m into the mailbox, waiting while it is full.
+// It returns ErrStopped if the actor stops first.
+func (r *Ref[M]) Send(m M) error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return ErrStopped
+	}
+	r.sending++
+	r.mu.Unlock()
+	defer r.sent()
+
+	// no lock is held while waiting, so Stop never waits for a full mailbox
+	select {
+	case r.mailbox <- m:
+		return nil
+	case <-r.stopping:
+		return ErrStopped
+	}
+}
+
+// sent is called when a Send is over.
+func (r *Ref[M]) sent() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sending--
+	if r.stopped && r.sending == 0 {
+		close(r.quiet)
+	}
+}
+
+// Ask sends the message made by msg, which must carry the reply channel,
+// and waits up to timeout for the answer.
+// It returns ErrStopped at once if the actor stops before answering.
+func Ask[M, R any](r *Ref[M], timeout time.Duration, msg func(reply chan<- R) M) (R, error) {
+	// buffered, so a late answer does not block the actor
+	reply := make(chan R, 1)
+	var zero R
+	if err := r.Send(msg(reply)); err != nil {
+		return zero, err
+	}
+	select {
+	case v := <-reply:
+		return v, nil
+	case <-r.done:
+		// the answer may have come just before the actor stopped
+		select {
+		case v := <-reply:
+			return v, nil
+		default:
+			return zero, ErrStopped
+		}
+	case <-time.After(timeout):
+		return zero, ErrTimeout
+	}
+}
+
+// Stop stops taking messages and waits until the ones in the mailbox
+// are handled, or ctx is done. Signalling the actor never blocks.
+func (r *Ref[M]) Stop(ctx context.Context) error {
+	r.shutdown()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops taking messages.
+func (r *Ref[M]) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.stopping)
+		if r.sending == 0 {
+			close(r.quiet)
+		}
+	}
+}
+
+// Done returns a channel that is closed when the actor has stopped.
+func (r *Ref[M]) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns why the actor stopped: nil after Stop, a *PanicError after a crash.
+// It is only set once Done is closed.
+func (r *Ref[M]) Err() error {
+	<-r.done
+	return r.err
+}
+
+// Restarts returns how often the actor was restarted.
+func (r *Ref[M]) Restarts() int {
+	return int(r.restarts.Load())
+}
+
+func (r *Ref[M]) loop(factory func() Actor[M], sup Supervision) {
+	defer close(r.done)
+	a := factory()
+	var restarts []time.Time
+
+	// handle reports whether the actor goes on after m
+	handle := func(m M) (ok bool) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			switch sup.Strategy {
+			case Resume:
+				ok = true
+			case Restart:
+				now := time.Now()
+				// forget the restarts outside the window
+				for len(restarts) > 0 && now.Sub(restarts[0]) > sup.Within {
+					restarts = restarts[1:]
+				}
+				if len(restarts) < sup.MaxRestarts {
+					restarts = append(restarts, now)
+					a = factory()
+					r.restarts.Add(1)
+					ok = true
+					return
+				}
+				fallthrough
+			default:
+				r.err = &PanicError{p}
+				ok = false
+			}
+		}()
+		a.Receive(m)
+		return true
+	}
+
+	for {
+		select {
+		case m := <-r.mailbox:
+			if !handle(m) {
+				r.crashed()
+				return
+			}
+		case <-r.stopping:
+			// no more Sends can start: handle what is left,
+			// including what the Sends going on still put in
+			if !r.drain(handle) {
+				r.crashed()
+				return
+			}
+			if s, ok := a.(Stopper); ok {
+				s.Stopped()
+			}
+			return
+		}
+	}
+}
+
+// drain passes every message to f until the mailbox is empty
+// and no Send is left, and reports whether f returned true for all.
+// The actor must be stopping.
+func (r *Ref[M]) drain(f func(m M) bool) bool {
+	for {
+		select {
+		case m := <-r.mailbox:
+			if !f(m) {
+				return false
+			}
+		case <-r.quiet:
+			for {
+				select {
+				case m := <-r.mailbox:
+					if !f(m) {
+						return false
+					}
+				default:
+					return true
+				}
+			}
+		}
+	}
+}
+
+// crashed stops taking messages after a fatal panic and drops the ones left.
+func (r *Ref[M]) crashed() {
+	r.shutdown()
+	r.drain(func(M) bool { return true })
+}
+
+// the messages of the counter actor
+type counterMsg interface{ counterMsg() }
+
+type inc struct{ key string }
+
+type value struct {
+	key   string
+	reply chan<- int
+}
+
+type crash struct{}
+
+type slow struct{ d time.Duration }
+
+func (inc) counterMsg()   {}
+func (value) counterMsg() {}
+func (crash) counterMsg() {}
+func (slow) counterMsg()  {}
+
+// counter is SafeCounter as an actor: the map belongs to it alone.
+type counter struct {
+	v map[string]int
+}
+
+func newCounter() Actor[counterMsg] {
+	return &counter{v: make(map[string]int)}
+}
+
+func (c *counter) Receive(m counterMsg) {
+	switch m := m.(type) {
+	case inc:
+		c.v[m.key]++
+	case value:
+		m.reply <- c.v[m.key]
+	case crash:
+		var v map[string]int
+		v["somekey"]++ // assignment to entry in nil map
+	case slow:
+		time.Sleep(m.d)
+	}
+}
+
+func (c *counter) Stopped() {
+	fmt.Println("counter stopped at", c.v["somekey"])
+}
+
+// valueOf asks c for the value of key.
+func valueOf(c *Ref[counterMsg], key string) (int, error) {
+	return Ask(c, time.Second, func(reply chan<- int) counterMsg { return value{key, reply} })
+}
+
+func main() {
+	ctx := context.Background()
+
+	// 8-mutex-counter.go without the mutex, and without the sleep:
+	// the question comes after the 1000 increments in the mailbox
+	c := Spawn("counter", 16, newCounter, Supervision{Strategy: Restart, MaxRestarts: 3, Within: time.Minute})
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Send(inc{"somekey"})
+		}()
+	}
+	wg.Wait()
+	// 1000 <nil>
+	fmt.Println(valueOf(c, "somekey"))
+
+	// Ask gives up: actor: ask timed out
+	c.Send(slow{50 * time.Millisecond})
+	_, err := Ask(c, 10*time.Millisecond, func(reply chan<- int) counterMsg { return value{"somekey", reply} })
+	fmt.Println(err)
+
+	// Restart: a crash starts over with a fresh map: 0 <nil> 1
+	c.Send(crash{})
+	v, err := valueOf(c, "somekey")
+	fmt.Println(v, err, c.Restarts())
+
+	// the fourth crash within the minute stops it:
+	// actor: panic: assignment to entry in nil map, then actor: stopped
+	for i := 0; i < 3; i++ {
+		c.Send(crash{})
+	}
+	fmt.Println(c.Err())
+	fmt.Println(c.Send(inc{"somekey"}))
+
+	// Ask does not wait out its timeout when the actor stops meanwhile:
+	// 0 actor: stopped true
+	st := Spawn("stopping", 16, newCounter, Supervision{Strategy: Stop})
+	st.Send(crash{})
+	start := time.Now()
+	v, err = valueOf(st, "somekey")
+	fmt.Println(v, err, time.Since(start) < 500*time.Millisecond)
+
+	// Resume: the crash costs only the message: 2 <nil>
+	r := Spawn("resuming", 16, newCounter, Supervision{Strategy: Resume})
+	r.Send(inc{"somekey"})
+	r.Send(crash{})
+	r.Send(inc{"somekey"})
+	fmt.Println(valueOf(r, "somekey"))
+
+	// a graceful stop handles what is in the mailbox first:
+	// counter stopped at 102, then <nil> <nil>
+	for i := 0; i < 100; i++ {
+		r.Send(inc{"somekey"})
+	}
+	fmt.Println(r.Stop(ctx), r.Err())
+
+	// a Send waiting for room gives up when the actor stops,
+	// and Stop gives up waiting after ctx:
+	// context deadline exceeded actor: stopped, then counter stopped at 1
+	b := Spawn("busy", 1, newCounter, Supervision{Strategy: Resume})
+	b.Send(slow{50 * time.Millisecond})
+	b.Send(inc{"somekey"})
+	blocked := make(chan error)
+	go func() { blocked <- b.Send(inc{"somekey"}) }()
+	time.Sleep(10 * time.Millisecond)
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	fmt.Println(b.Stop(tctx), <-blocked)
+	<-b.Done()
+}
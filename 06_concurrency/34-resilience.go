@@ -0,0 +1,482 @@
+/*
+ 1. the crawler of `9-exercise-web-crawler.go` calls `Fetcher.Fetch` directly:
+    a slow backend holds it up, a failing one fails it, every time
+ 2. a `Policy` runs a call for us, and decides what to do when it fails:
+    `Timeout` gives the call a context with a deadline;
+    `Retry` tries again, waiting Base, 2*Base, 4*Base ... (at most Max),
+    each wait with random jitter, so clients do not retry in step;
+    `Breaker` opens the circuit after Threshold failures in a row,
+    and then calls fail at once with ErrOpen; after OpenFor one trial call
+    is let through (half-open): success closes it, failure opens it again
+ 3. `Compose(Retry, Breaker, Timeout)` nests them, outermost first:
+    every retry goes through the breaker, every try gets its own timeout
+ 4. `Run` calls a function returning a value of any type through a policy,
+    and `ResilientFetcher` decorates a `Fetcher` with one
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy runs fn, with whatever protection it adds.
+type Policy interface {
+	Execute(ctx context.Context, fn func(context.Context) error) error
+}
+
+// Run calls fn through p and returns its value.
+func Run[T any](ctx context.Context, p Policy, fn func(context.Context) (T, error)) (T, error) {
+	var (
+		mu   sync.Mutex
+		v    T
+		done bool // a call given up by Timeout may still return later
+	)
+	err := p.Execute(ctx, func(ctx context.Context) error {
+		got, err := fn(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil && !done {
+			v = got
+		}
+		return err
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// compose nests policies, the first one outermost.
+type compose []Policy
+
+// Compose returns the policies nested, the first one outermost.
+func Compose(policies ...Policy) Policy {
+	return compose(policies)
+}
+
+func (c compose) Execute(ctx context.Context, fn func(context.Context) error) error {
+	if len(c) == 0 {
+		return fn(ctx)
+	}
+	return c[0].Execute(ctx, func(ctx context.Context) error {
+		return c[1:].Execute(ctx, fn)
+	})
+}
+
+// Timeout gives every call a context that is done after the duration.
+type Timeout time.Duration
+
+// Execute returns when fn returns or when the time is up, whichever is first.
+// fn should give up when its context is done; if it does not,
+// it keeps running in the background and its result is dropped.
+func (t Timeout) Execute(ctx context.Context, fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t))
+	defer cancel()
+	// buffered, so fn can return after nobody waits anymore
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retry calls again after a failure, with exponential backoff and jitter.
+type Retry struct {
+	Attempts int           // including the first one; less than 1 means 1
+	Base     time.Duration // the wait before the second attempt
+	Max      time.Duration // the longest wait; 0 or less means no limit
+	Jitter   float64       // 0.5 makes a wait of d anything between d/2 and d; kept within [0, 1]
+
+	// Retryable reports whether err is worth another try;
+	// nil means every error except ErrOpen.
+	Retryable func(err error) bool
+}
+
+// Execute calls fn until it succeeds, the attempts are used up,
+// the error is not retryable, or ctx is done; it returns the last error.
+func (r Retry) Execute(ctx context.Context, fn func(context.Context) error) error {
+	attempts := r.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(r.backoff(attempt)):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		if err = fn(ctx); err == nil || !r.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (r Retry) retryable(err error) bool {
+	if r.Retryable != nil {
+		return r.Retryable(err)
+	}
+	return !errors.Is(err, ErrOpen)
+}
+
+// backoff is the wait before the given attempt (1 for the second one).
+func (r Retry) backoff(attempt int) time.Duration {
+	max := r.Max
+	if max <= 0 {
+		max = math.MaxInt64
+	}
+	d := r.Base << (attempt - 1)
+	// a shift past the top bit loses the base
+	if d > max || d <= 0 || d>>(attempt-1) != r.Base {
+		d = max
+	}
+	jitter := r.Jitter
+	switch {
+	case !(jitter > 0): // NaN, too
+		jitter = 0
+	case jitter > 1:
+		jitter = 1
+	}
+	return d - time.Duration(jitter*rand.Float64()*float64(d))
+}
+
+// Clock is what the breaker needs to know about time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// outside of the demo: NewBreaker(5, 30*time.Second, realClock{})
+var _ Clock = realClock{}
+
+// fakeClock is a clock that only moves by Advance.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+// The breaker only reads the time, so there are no timers to fire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	return [...]string{"closed", "open", "half-open"}[s]
+}
+
+// ErrOpen is returned by a breaker that does not let calls through.
+var ErrOpen = errors.New("breaker: circuit open")
+
+// Breaker is a circuit breaker.
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	state    State
+	failures int       // in a row, while closed
+	openedAt time.Time // while open
+	trial    bool      // a trial call is running, while half-open
+}
+
+// NewBreaker returns a closed breaker that opens after threshold failures
+// in a row, and tries again after openFor.
+func NewBreaker(threshold int, openFor time.Duration, clock Clock) *Breaker {
+	return &Breaker{threshold: threshold, openFor: openFor, clock: clock}
+}
+
+// State returns the state, moving from open to half-open if openFor has passed.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tick()
+	return b.state
+}
+
+// tick moves from open to half-open once openFor has passed; b.mu must be held.
+func (b *Breaker) tick() {
+	if b.state == Open && b.clock.Now().Sub(b.openedAt) >= b.openFor {
+		b.state = HalfOpen
+	}
+}
+
+// Execute calls fn unless the circuit is open, and counts the result.
+// Errors caused by ctx itself do not count as failures of the backend.
+func (b *Breaker) Execute(ctx context.Context, fn func(context.Context) error) error {
+	b.mu.Lock()
+	b.tick()
+	isTrial := false
+	switch {
+	case b.state == Open, b.state == HalfOpen && b.trial:
+		b.mu.Unlock()
+		return ErrOpen
+	case b.state == HalfOpen:
+		b.trial, isTrial = true, true
+	}
+	b.mu.Unlock()
+
+	err := fn(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// only the trial call ends the trial; a call that started while closed
+	// and ends after the circuit opened says nothing about the trial
+	if isTrial {
+		b.trial = false
+	}
+	switch {
+	case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+		// the caller gave up, the backend did nothing wrong
+	case !isTrial && b.state != Closed:
+		// a late result of a call from before the circuit opened
+	case err == nil:
+		b.state, b.failures = Closed, 0
+	case isTrial:
+		b.state, b.openedAt = Open, b.clock.Now()
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state, b.openedAt, b.failures = Open, b.clock.Now(), 0
+		}
+	}
+	return err
+}
+
+// Fetcher of `9-exercise-web-crawler.go`.
+type Fetcher interface {
+	// Fetch returns the body of URL and
+	// a slice of URLs found on that page.
+	Fetch(url string) (body string, urls []string, err error)
+}
+
+// ResilientFetcher is a Fetcher that calls another one through a policy.
+type ResilientFetcher struct {
+	Fetcher Fetcher
+	Policy  Policy
+}
+
+type page struct {
+	body string
+	urls []string
+}
+
+func (f ResilientFetcher) Fetch(url string) (string, []string, error) {
+	p, err := Run(context.Background(), f.Policy, func(ctx context.Context) (page, error) {
+		body, urls, err := f.Fetcher.Fetch(url)
+		return page{body, urls}, err
+	})
+	return p.body, p.urls, err
+}
+
+// fakeFetcher is Fetcher that returns canned results.
+type fakeFetcher map[string]*page
+
+func (f fakeFetcher) Fetch(url string) (string, []string, error) {
+	if res, ok := f[url]; ok {
+		return res.body, res.urls, nil
+	}
+	return "", nil, fmt.Errorf("not found: %s", url)
+}
+
+// fetcher is a populated fakeFetcher.
+var fetcher = fakeFetcher{
+	"https://golang.org/": &page{
+		"The Go Programming Language",
+		[]string{"https://golang.org/pkg/", "https://golang.org/cmd/"},
+	},
+	"https://golang.org/pkg/": &page{
+		"Packages",
+		[]string{"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"},
+	},
+	"https://golang.org/pkg/fmt/": &page{
+		"Package fmt",
+		[]string{"https://golang.org/", "https://golang.org/pkg/"},
+	},
+	"https://golang.org/pkg/os/": &page{
+		"Package os",
+		[]string{"https://golang.org/", "https://golang.org/pkg/"},
+	},
+}
+
+// ErrUnavailable is what a broken backend returns.
+var ErrUnavailable = errors.New("backend unavailable")
+
+// flakyFetcher fails the first failures calls for every URL,
+// and fails every call while down; it counts the calls.
+type flakyFetcher struct {
+	Fetcher
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+	total int
+	down  bool
+	delay time.Duration
+}
+
+func (f *flakyFetcher) Fetch(url string) (string, []string, error) {
+	f.mu.Lock()
+	f.calls[url]++
+	f.total++
+	fail := f.down || f.calls[url] <= f.failures
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+	if fail {
+		return "", nil, ErrUnavailable
+	}
+	return f.Fetcher.Fetch(url)
+}
+
+func (f *flakyFetcher) set(down bool, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down, f.delay = down, delay
+}
+
+func (f *flakyFetcher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.total
+}
+
+// crawl is a serial Crawl: the URLs found, and the errors.
+func crawl(url string, depth int, f Fetcher, found map[string]error) {
+	if _, ok := found[url]; ok || depth <= 0 {
+		return
+	}
+	_, urls, err := f.Fetch(url)
+	found[url] = err
+	for _, u := range urls {
+		crawl(u, depth-1, f, found)
+	}
+}
+
+func report(found map[string]error) {
+	urls := make([]string, 0, len(found))
+	for u := range found {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for _, u := range urls {
+		fmt.Printf("    %s: %v\n", u, found[u])
+	}
+}
+
+func main() {
+	retry := Retry{Attempts: 3, Base: time.Millisecond, Max: 10 * time.Millisecond, Jitter: 0.5,
+		// a page that is not found will not be found on the next try either
+		Retryable: func(err error) bool { return errors.Is(err, ErrUnavailable) }}
+
+	// every page fails twice before it works: no retries, no pages
+	flaky := &flakyFetcher{Fetcher: fetcher, failures: 2, calls: make(map[string]int)}
+	found := make(map[string]error)
+	crawl("https://golang.org/", 4, flaky, found)
+	// https://golang.org/: backend unavailable
+	report(found)
+
+	// with retries every page is found on the third attempt
+	flaky = &flakyFetcher{Fetcher: fetcher, failures: 2, calls: make(map[string]int)}
+	found = make(map[string]error)
+	crawl("https://golang.org/", 4, ResilientFetcher{flaky, retry}, found)
+	// https://golang.org/: <nil>
+	// https://golang.org/cmd/: not found: https://golang.org/cmd/
+	// https://golang.org/pkg/: <nil>
+	// https://golang.org/pkg/fmt/: <nil>
+	// https://golang.org/pkg/os/: <nil>
+	report(found)
+	// 15 calls for 5 pages
+	fmt.Println(flaky.count(), "calls for", len(found), "pages")
+
+	// a backend that is down: the breaker opens after 3 failures in a row,
+	// and the retries of the later calls stop at ErrOpen
+	clock := &fakeClock{now: time.Date(2022, 9, 3, 16, 0, 0, 0, time.UTC)}
+	breaker := NewBreaker(3, time.Minute, clock)
+	flaky = &flakyFetcher{Fetcher: fetcher, calls: make(map[string]int), down: true}
+	f := ResilientFetcher{flaky, Compose(retry, breaker, Timeout(10*time.Millisecond))}
+	for i := 0; i < 3; i++ {
+		_, _, err := f.Fetch("https://golang.org/")
+		// backend unavailable open 3
+		// breaker: circuit open open 3
+		// breaker: circuit open open 3
+		fmt.Println(err, breaker.State(), flaky.count())
+	}
+
+	// a minute later one trial call is let through: it fails, open again,
+	// and the retry after it gets ErrOpen
+	clock.Advance(time.Minute)
+	// half-open
+	fmt.Println(breaker.State())
+	_, _, err := f.Fetch("https://golang.org/")
+	// breaker: circuit open open 4
+	fmt.Println(err, breaker.State(), flaky.count())
+
+	// the backend is back, but slow: the timeout fails the trial call,
+	// and this policy does not retry timeouts
+	flaky.set(false, 50*time.Millisecond)
+	clock.Advance(time.Minute)
+	_, _, err = f.Fetch("https://golang.org/")
+	// context deadline exceeded open 5
+	fmt.Println(err, breaker.State(), flaky.count())
+
+	// and then fast again: the trial succeeds and closes the circuit
+	flaky.set(false, 0)
+	clock.Advance(time.Minute)
+	body, _, err := f.Fetch("https://golang.org/")
+	// "The Go Programming Language" <nil> closed 6
+	fmt.Printf("%q %v %v %d\n", body, err, breaker.State(), flaky.count())
+
+	// without Max the waits just double: [100ms 200ms 400ms]
+	fmt.Println([]time.Duration{
+		Retry{Base: 100 * time.Millisecond}.backoff(1),
+		Retry{Base: 100 * time.Millisecond}.backoff(2),
+		Retry{Base: 100 * time.Millisecond}.backoff(3),
+	})
+
+	// the zero Retry still calls once: backend unavailable 1
+	calls := 0
+	err = Retry{}.Execute(context.Background(), func(context.Context) error {
+		calls++
+		return ErrUnavailable
+	})
+	fmt.Println(err, calls)
+}
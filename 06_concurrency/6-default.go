@@ -2,24 +2,44 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 )
 
+// Clock is the part of the time package tickBoom uses,
+// so a fake clock can stand in for it (see 25-virtual-clock.go).
+type Clock interface {
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	Tick(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Tick(d time.Duration) <-chan time.Time  { return time.Tick(d) }
+
 func main() {
-	tick := time.Tick(100 * time.Millisecond)
-	boom := time.After(500 * time.Millisecond)
+	tickBoom(realClock{}, os.Stdout, 100*time.Millisecond, 500*time.Millisecond)
+}
+
+func tickBoom(clock Clock, w io.Writer, every, after time.Duration) {
+	tick := clock.Tick(every)
+	boom := clock.After(after)
 	for {
 		select {
 		case <-tick:
-			fmt.Println("tick.")
+			fmt.Fprintln(w, "tick.")
 		case <-boom:
-			fmt.Println("BOOM!")
+			fmt.Fprintln(w, "BOOM!")
 			return
 		default:
 			// the default case in a select is run
 			// if no other case is ready
-			fmt.Println("    .")
-			time.Sleep(50 * time.Millisecond)
+			fmt.Fprintln(w, "    .")
+			clock.Sleep(every / 2)
 		}
 	}
 }
@@ -6,6 +6,16 @@ import (
 	"time"
 )
 
+// Clock is the part of the time package main uses,
+// so a fake clock can stand in for it (see 25-virtual-clock.go).
+type Clock interface {
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
 // SafeCounter is safe to use concurrently.
 type SafeCounter struct {
 	mu sync.Mutex
@@ -29,6 +39,10 @@ func (c *SafeCounter) Value(key string) int {
 }
 
 func main() {
+	count(realClock{})
+}
+
+func count(clock Clock) {
 	// initialize with the {key:value} format
 	c := SafeCounter{v: make(map[string]int)}
 
@@ -39,7 +53,7 @@ func main() {
 	}
 
 	// sleep 20ms
-	time.Sleep(20 * time.Millisecond)
+	clock.Sleep(20 * time.Millisecond)
 
 	// check the results
 	// with the lock, the access is safe